@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"ps-diff/powerschool"
+)
+
+// AttendanceRecord is an absence or tardy PowerSchool recorded for a class.
+type AttendanceRecord struct {
+	ID        int64  `json:"id"`
+	ClassID   int64  `json:"classId"`
+	ClassName string `json:"className"`
+	Date      string `json:"date"`
+	// "absence" or "tardy"
+	Kind string `json:"kind"`
+	// The attendance code's description, e.g. "Excused Absence"
+	Description string `json:"description"`
+}
+
+// attendanceRecords picks out the absences and tardies in the student's
+// attendance data, skipping classes left out by the class filters. Records
+// are tied to their class through the section enrollment (ccid) they were
+// taken for.
+func attendanceRecords(student *powerschool.StudentDataVO, sections *sectionNames) []AttendanceRecord {
+	sectionByEnrollment := make(map[int64]int64)
+	for _, section := range student.Sections {
+		for _, enrollment := range section.Enrollments {
+			sectionByEnrollment[enrollment.Id] = section.Id
+		}
+	}
+	codes := make(map[int64]*powerschool.AttendanceCodeVO)
+	for _, code := range student.AttendanceCodes {
+		codes[code.Id] = code
+	}
+
+	records := []AttendanceRecord{}
+	for _, attendance := range student.Attendance {
+		code := codes[attendance.AttCodeid]
+		if code == nil {
+			continue
+		}
+		kind := attendanceKind(code)
+		if kind == "" {
+			continue
+		}
+		sectionID := sectionByEnrollment[attendance.Ccid]
+		className := sections.name(sectionID)
+		if !classMonitored(sectionID, className) {
+			continue
+		}
+		date := attendance.AttDate
+		if len(date) > len("2006-01-02") {
+			date = date[:len("2006-01-02")]
+		}
+		records = append(records, AttendanceRecord{
+			ID:          attendance.Id,
+			ClassID:     sectionID,
+			ClassName:   className,
+			Date:        date,
+			Kind:        kind,
+			Description: code.Description,
+		})
+	}
+	return records
+}
+
+// attendanceKind classifies an attendance code as "tardy", "absence" or ""
+// for codes that mean the student was there. Schools name their codes
+// differently, so this goes by the code's description.
+func attendanceKind(code *powerschool.AttendanceCodeVO) string {
+	description := strings.ToLower(code.Description)
+	switch {
+	case code.AttCode == "" || strings.Contains(description, "present"):
+		return ""
+	case strings.Contains(description, "tard") || strings.Contains(description, "late"):
+		return "tardy"
+	default:
+		return "absence"
+	}
+}
+
+// compareAttendance returns a change for every absence or tardy in
+// newRecords that wasn't in oldRecords.
+func compareAttendance(oldRecords, newRecords []AttendanceRecord) []Change {
+	seen := make(map[int64]bool, len(oldRecords))
+	for _, record := range oldRecords {
+		seen[record.ID] = true
+	}
+
+	var changes []Change
+	for _, record := range newRecords {
+		if seen[record.ID] {
+			continue
+		}
+		kind := NewAbsence
+		if record.Kind == "tardy" {
+			kind = NewTardy
+		}
+		change := Change{Kind: kind, ClassID: record.ClassID, ClassName: record.ClassName, New: record.Date}
+		if record.Description != "" {
+			change.Detail = fmt.Sprintf(" (%s)", record.Description)
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
@@ -0,0 +1,158 @@
+package main
+
+import "fmt"
+
+// ChangeKind is what kind of change a Change is.
+type ChangeKind string
+
+const (
+	GradeChanged           ChangeKind = "grade_changed"
+	ClassAdded             ChangeKind = "class_added"
+	ClassRemoved           ChangeKind = "class_removed"
+	AssignmentGradeChanged ChangeKind = "assignment_grade_changed"
+	AssignmentGraded       ChangeKind = "assignment_graded"
+	NewAssignment          ChangeKind = "new_assignment"
+	AssignmentRemoved      ChangeKind = "assignment_removed"
+	AssignmentRenamed      ChangeKind = "assignment_renamed"
+	DueDateChanged         ChangeKind = "due_date_changed"
+	CommentChanged         ChangeKind = "comment_changed"
+	CategoryChanged        ChangeKind = "category_changed"
+	NewAbsence             ChangeKind = "new_absence"
+	NewTardy               ChangeKind = "new_tardy"
+	MarkedMissing          ChangeKind = "marked_missing"
+	MarkedLate             ChangeKind = "marked_late"
+)
+
+// Change is one difference found between two polls. Old and New are the
+// values as shown to the user (grades, names, dates, comments or categories,
+// depending on Kind), "" where there's nothing to show.
+type Change struct {
+	Kind      ChangeKind
+	ClassID   int64
+	ClassName string
+	// The assignment's name, "" for changes to a class
+	Name string
+	Old  string
+	New  string
+	// New minus Old for grade changes where both are numeric, otherwise 0
+	Delta float64
+	// Extra context appended to the text, like a class's grade trend
+	Detail string
+}
+
+// gradeDelta returns newGrade minus oldGrade, or 0 unless both are numeric.
+func gradeDelta(oldGrade, newGrade string) float64 {
+	oldValue, ok1 := parseGrade(oldGrade)
+	newValue, ok2 := parseGrade(newGrade)
+	if !ok1 || !ok2 {
+		return 0
+	}
+	return newValue - oldValue
+}
+
+// String renders the change as the line sent in notifications, with its
+// marker and message template applied.
+func (c Change) String() string {
+	message := changeMessage{Name: c.Name, ClassName: c.ClassName, OldGrade: c.Old, NewGrade: c.New}
+	var text string
+	switch c.Kind {
+	case GradeChanged:
+		text = fmt.Sprintf("Grade changed for %s: %s -> %s", c.ClassName, c.Old, c.New)
+	case ClassAdded:
+		text = fmt.Sprintf("New class added: %s with grade %s", c.ClassName, c.New)
+	case ClassRemoved:
+		text = fmt.Sprintf("Class removed: %s", c.ClassName)
+	case AssignmentGradeChanged:
+		text = renderMessage("grade_changed", message)
+	case AssignmentGraded:
+		text = renderMessage("assignment_graded", message)
+	case NewAssignment:
+		text = renderMessage("new_assignment", message)
+	case AssignmentRemoved:
+		text = renderMessage("assignment_removed", message)
+	case AssignmentRenamed:
+		text = fmt.Sprintf("Assignment renamed in %s: '%s' -> '%s'", c.ClassName, c.Old, c.New)
+	case DueDateChanged:
+		text = fmt.Sprintf("Due date changed for '%s' in class %s: %s -> %s", c.Name, c.ClassName, c.Old, c.New)
+	case CommentChanged:
+		text = describeCommentChange(c)
+	case CategoryChanged:
+		text = fmt.Sprintf("Assignment '%s' in class %s moved: %s -> %s (high impact: category weights may shift the class grade)",
+			c.Name, c.ClassName, c.Old, c.New)
+	case NewAbsence:
+		text = fmt.Sprintf("New absence recorded in %s on %s", c.ClassName, c.New)
+	case NewTardy:
+		text = fmt.Sprintf("New tardy recorded in %s on %s", c.ClassName, c.New)
+	case MarkedMissing:
+		text = fmt.Sprintf("⚠️ '%s' in %s is now marked MISSING", c.Name, c.ClassName)
+	case MarkedLate:
+		text = fmt.Sprintf("⚠️ '%s' in %s is now marked LATE", c.Name, c.ClassName)
+	default:
+		text = fmt.Sprintf("%s changed for %s: %s -> %s", c.Kind, c.ClassName, c.Old, c.New)
+	}
+	if c.Detail != "" {
+		text += c.Detail
+	}
+	return c.marker() + text
+}
+
+// describeCommentChange words a change to the teacher's comment on an
+// assignment.
+func describeCommentChange(c Change) string {
+	switch {
+	case c.Old == "":
+		return fmt.Sprintf("Teacher commented on '%s' in class %s: \"%s\"", c.Name, c.ClassName, c.New)
+	case c.New == "":
+		return fmt.Sprintf("Teacher comment removed from '%s' in class %s (was \"%s\")", c.Name, c.ClassName, c.Old)
+	default:
+		return fmt.Sprintf("Teacher comment changed on '%s' in class %s: \"%s\" -> \"%s\"", c.Name, c.ClassName, c.Old, c.New)
+	}
+}
+
+// direction returns 1 for a grade change that went up, -1 for one that went
+// down, and 0 for anything else, including grades that aren't numeric.
+func (c Change) direction() int {
+	if c.Kind != GradeChanged && c.Kind != AssignmentGradeChanged {
+		return 0
+	}
+	switch {
+	case c.Delta > 0:
+		return 1
+	case c.Delta < 0:
+		return -1
+	}
+	return 0
+}
+
+// urgent reports whether the change should lead its notification.
+func (c Change) urgent() bool {
+	return c.Kind == MarkedMissing || c.Kind == MarkedLate
+}
+
+// marker returns the configured marker for the change, if any.
+func (c Change) marker() string {
+	switch c.Kind {
+	case GradeChanged, AssignmentGradeChanged:
+		switch {
+		case c.Delta > 0:
+			return changeMarker("increase")
+		case c.Delta < 0:
+			return changeMarker("decrease")
+		}
+	case ClassAdded, NewAssignment:
+		return changeMarker("new")
+	case ClassRemoved, AssignmentRemoved:
+		return changeMarker("removed")
+	}
+	return ""
+}
+
+// groupChanges groups changes by class route and class for sending.
+func groupChanges(changes []Change) *routedChanges {
+	grouped := &routedChanges{}
+	for _, change := range changes {
+		grouped.add(classRoute(change.ClassID, change.ClassName), change.ClassName,
+			MessageLine{Text: change.String(), Direction: change.direction()})
+	}
+	return grouped
+}
@@ -0,0 +1,770 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting the notifier needs. It's read from an optional
+// YAML or JSON file, then environment variables override the file.
+type Config struct {
+	PowerSchoolURL      string            `json:"powerschool_url" yaml:"powerschool_url"`
+	PowerSchoolUsername string            `json:"powerschool_username" yaml:"powerschool_username"`
+	PowerSchoolPassword string            `json:"powerschool_password" yaml:"powerschool_password"`
+	PowerSchoolHeaders  map[string]string `json:"powerschool_headers" yaml:"powerschool_headers"`
+	PollInterval        string            `json:"poll_interval" yaml:"poll_interval"`
+
+	// Files to read the PowerSchool password and Discord webhook URL from,
+	// e.g. Docker or Kubernetes secrets. They take precedence over the
+	// inline values.
+	PowerSchoolPasswordFile string `json:"powerschool_password_file" yaml:"powerschool_password_file"`
+	DiscordWebhookURLFile   string `json:"discord_webhook_url_file" yaml:"discord_webhook_url_file"`
+
+	// Randomly shift each poll by up to this percentage of the poll interval,
+	// either way, so polls don't land at the same moment every time. 0 disables it.
+	PollJitter float64 `json:"poll_jitter" yaml:"poll_jitter"`
+
+	// How long any single request to PowerSchool or a notifier may take
+	HTTPTimeout Duration `json:"http_timeout" yaml:"http_timeout"`
+
+	// Proxy for requests to PowerSchool and the notifiers, e.g.
+	// "http://proxy.example.com:3128". Empty uses HTTP_PROXY, HTTPS_PROXY and
+	// NO_PROXY from the environment.
+	ProxyURL string `json:"proxy_url" yaml:"proxy_url"`
+
+	// Regular expression selecting which current reporting terms to compare, by
+	// title. The default "^Q" picks quarters; use e.g. "^S" for semesters.
+	TermTitlePattern string `json:"term_title_pattern" yaml:"term_title_pattern"`
+	// Exact reporting term IDs to compare, e.g. when two overlapping terms
+	// match the pattern. When set, the pattern and term dates are ignored.
+	TermIDs []int64 `json:"term_ids" yaml:"term_ids"`
+
+	// IANA timezone (e.g. "America/Chicago") used for term dates, quiet hours,
+	// the digest and recap. Empty uses the system's local zone.
+	Timezone string `json:"timezone" yaml:"timezone"`
+
+	// Students to monitor on a parent account, by student ID, first name or full
+	// name (case-insensitive). Empty monitors every student on the account.
+	Students []string `json:"students" yaml:"students"`
+
+	// Which backend to send notifications to: "discord" (default), "slack", "email",
+	// "telegram", "ntfy", "pushover", "webhook" or "matrix". List several in Notifiers to send to all of them.
+	Notifier          string   `json:"notifier" yaml:"notifier"`
+	Notifiers         []string `json:"notifiers" yaml:"notifiers"`
+	DiscordWebhookURL string   `json:"discord_webhook_url" yaml:"discord_webhook_url"`
+	DiscordEmbeds     bool     `json:"discord_embeds" yaml:"discord_embeds"`
+	DiscordUsername   string   `json:"discord_username" yaml:"discord_username"`
+	DiscordAvatarURL  string   `json:"discord_avatar_url" yaml:"discord_avatar_url"`
+
+	// Discord webhooks for specific classes, keyed by class name or section ID.
+	// Changes to other classes, and summaries, go to the notifiers above.
+	ClassWebhooks   map[string]string `json:"class_webhooks" yaml:"class_webhooks"`
+	SlackWebhookURL string            `json:"slack_webhook_url" yaml:"slack_webhook_url"`
+
+	// "immediate" (default) sends changes as they're found; "digest" collects
+	// them into DigestFile and sends one message a day at DigestTime (HH:MM).
+	NotifyMode string `json:"notify_mode" yaml:"notify_mode"`
+	DigestTime string `json:"digest_time" yaml:"digest_time"`
+	DigestFile string `json:"digest_file" yaml:"digest_file"`
+
+	// text/template strings for assignment change messages; see MessageTemplates.
+	// Templates left out keep the default wording.
+	MessageTemplates MessageTemplates `json:"message_templates" yaml:"message_templates"`
+
+	// Prefix changes with emoji: ⬆️/⬇️ for grades going up or down, 🆕 for new
+	// classes and assignments and ❌ for removed ones. ChangeMarkers sets the
+	// marker for any of "increase", "decrease", "new" and "removed" instead,
+	// with or without ChangeEmoji; "" leaves one out.
+	ChangeEmoji   bool              `json:"change_emoji" yaml:"change_emoji"`
+	ChangeMarkers map[string]string `json:"change_markers" yaml:"change_markers"`
+
+	// Notifications during QuietHours (e.g. "22:00-07:00") are held in
+	// QuietHoursFile and sent when quiet hours end. Empty disables them.
+	// QuietWeekends and Holidays (e.g. "2025-12-25") hold them all day.
+	QuietHours     string   `json:"quiet_hours" yaml:"quiet_hours"`
+	QuietWeekends  bool     `json:"quiet_weekends" yaml:"quiet_weekends"`
+	Holidays       []string `json:"holidays" yaml:"holidays"`
+	QuietHoursFile string   `json:"quiet_hours_file" yaml:"quiet_hours_file"`
+
+	// Per-student overrides of the settings above, keyed like Students.
+	StudentSettings map[string]StudentSettings `json:"student_settings" yaml:"student_settings"`
+
+	// SMTP settings for the email notifier
+	SMTPHost     string   `json:"smtp_host" yaml:"smtp_host"`
+	SMTPPort     int      `json:"smtp_port" yaml:"smtp_port"`
+	SMTPUsername string   `json:"smtp_username" yaml:"smtp_username"`
+	SMTPPassword string   `json:"smtp_password" yaml:"smtp_password"`
+	EmailFrom    string   `json:"email_from" yaml:"email_from"`
+	EmailTo      []string `json:"email_to" yaml:"email_to"`
+
+	// Bot token and chat to send to for the Telegram notifier
+	TelegramBotToken string `json:"telegram_bot_token" yaml:"telegram_bot_token"`
+	TelegramChatID   string `json:"telegram_chat_id" yaml:"telegram_chat_id"`
+
+	// Topic URL (e.g. https://ntfy.sh/my-grades) for the ntfy notifier, with an
+	// optional title, priority (1-5 or min, low, default, high, urgent) and
+	// access token for protected topics
+	NtfyURL      string `json:"ntfy_url" yaml:"ntfy_url"`
+	NtfyTitle    string `json:"ntfy_title" yaml:"ntfy_title"`
+	NtfyPriority string `json:"ntfy_priority" yaml:"ntfy_priority"`
+	NtfyToken    string `json:"ntfy_token" yaml:"ntfy_token"`
+
+	// Application token and user key for the Pushover notifier, with an
+	// optional title. Grade drops are sent at high priority.
+	PushoverToken string `json:"pushover_token" yaml:"pushover_token"`
+	PushoverUser  string `json:"pushover_user" yaml:"pushover_user"`
+	PushoverTitle string `json:"pushover_title" yaml:"pushover_title"`
+
+	// Homeserver (e.g. https://matrix.example.org), access token and room ID
+	// (e.g. !abc123:example.org) for the Matrix notifier. MatrixHTML also
+	// sends an HTML version of each message.
+	MatrixHomeserverURL string `json:"matrix_homeserver_url" yaml:"matrix_homeserver_url"`
+	MatrixAccessToken   string `json:"matrix_access_token" yaml:"matrix_access_token"`
+	MatrixRoomID        string `json:"matrix_room_id" yaml:"matrix_room_id"`
+	MatrixHTML          bool   `json:"matrix_html" yaml:"matrix_html"`
+
+	// Endpoint for the generic webhook notifier. WebhookBody is a text/template
+	// rendering the JSON body, with the message in .Message; use {{json .Message}}
+	// to quote it. It defaults to {"message": ...}.
+	WebhookURL     string            `json:"webhook_url" yaml:"webhook_url"`
+	WebhookMethod  string            `json:"webhook_method" yaml:"webhook_method"`
+	WebhookHeaders map[string]string `json:"webhook_headers" yaml:"webhook_headers"`
+	WebhookBody    string            `json:"webhook_body" yaml:"webhook_body"`
+
+	// Where to keep the last seen data: "json" (default) uses StateFile below,
+	// "sqlite" keeps everything in DatabaseFile instead.
+	Storage      string `json:"storage" yaml:"storage"`
+	DatabaseFile string `json:"database_file" yaml:"database_file"`
+
+	// StateFile holds the last seen classes and assignments. The separate
+	// backup files from older versions are read once, if there's no StateFile
+	// yet, and removed after the first save.
+	StateFile string `json:"state_file" yaml:"state_file"`
+	// Before each save, archive the previous StateFile with a timestamp (e.g.
+	// state_123456-20240115T090000.json), keeping the newest BackupKeep
+	// archives and none older than BackupMaxAge. Both 0 (default) disables it.
+	BackupKeep   int      `json:"backup_keep" yaml:"backup_keep"`
+	BackupMaxAge Duration `json:"backup_max_age" yaml:"backup_max_age"`
+
+	BackupClassesFile     string `json:"backup_classes_file" yaml:"backup_classes_file"`
+	BackupAssignmentsFile string `json:"backup_assignments_file" yaml:"backup_assignments_file"`
+
+	// Webhook used while the primary one stays rate-limited for FallbackAfterRateLimit,
+	// until PrimaryRecoveryChecks health checks in a row succeed.
+	FallbackWebhookURL     string   `json:"fallback_webhook_url" yaml:"fallback_webhook_url"`
+	FallbackAfterRateLimit Duration `json:"fallback_after_rate_limit" yaml:"fallback_after_rate_limit"`
+	PrimaryRecoveryChecks  int      `json:"primary_recovery_checks" yaml:"primary_recovery_checks"`
+
+	// How long to wait after a failed notification before trying to deliver
+	// again. Undelivered notifications are kept in NotificationQueueFile, one
+	// per notifier, so they survive a restart.
+	NotificationFailureCooldown Duration `json:"notification_failure_cooldown" yaml:"notification_failure_cooldown"`
+	NotificationQueueFile       string   `json:"notification_queue_file" yaml:"notification_queue_file"`
+
+	// Skip a change identical to one sent within DedupeWindow, e.g. when a
+	// grade flips back and forth between polls. It has to be at least the poll
+	// interval to catch anything. Sent changes are tracked in DedupeFile across
+	// restarts. 0 disables it.
+	DedupeWindow Duration `json:"dedupe_window" yaml:"dedupe_window"`
+	DedupeFile   string   `json:"dedupe_file" yaml:"dedupe_file"`
+
+	// Assignment categories to compare, matched case-insensitively. If
+	// MonitoredCategories is non-empty only those categories are monitored;
+	// anything in IgnoredCategories is always left out.
+	MonitoredCategories []string `json:"monitored_categories" yaml:"monitored_categories"`
+	IgnoredCategories   []string `json:"ignored_categories" yaml:"ignored_categories"`
+
+	// Classes to compare, by exact name (case-insensitive) or section ID. If
+	// OnlyClasses is non-empty only those classes are compared; anything in
+	// IgnoreClasses is always left out, including from GPA and grade alerts.
+	IgnoreClasses []string `json:"ignore_classes" yaml:"ignore_classes"`
+	OnlyClasses   []string `json:"only_classes" yaml:"only_classes"`
+
+	// Assignments due more than this many days ago are tracked but never
+	// announced as new (e.g. after the backup is rebuilt). 0 disables the check.
+	NewAssignmentMaxAgeDays int `json:"new_assignment_max_age_days" yaml:"new_assignment_max_age_days"`
+
+	// Grades teachers enter as placeholders for unsubmitted work (e.g. "0" or
+	// "100"). Changes to these are recorded but not notified.
+	PlaceholderGrades []string `json:"placeholder_grades" yaml:"placeholder_grades"`
+
+	// Time of day (HH:MM) to send an end-of-day recap of the day's changes.
+	// Empty disables the recap.
+	RecapTime          string `json:"recap_time" yaml:"recap_time"`
+	RecapSkipEmptyDays bool   `json:"recap_skip_empty_days" yaml:"recap_skip_empty_days"`
+	RecapChangesFile   string `json:"recap_changes_file" yaml:"recap_changes_file"`
+
+	// Remind about ungraded assignments due within this many days, once per
+	// assignment. Sent reminders are tracked in RemindersFile. 0 disables it.
+	ReminderDays  int    `json:"reminder_days" yaml:"reminder_days"`
+	RemindersFile string `json:"reminders_file" yaml:"reminders_file"`
+
+	// Notify when a fetch takes this many times longer than the rolling
+	// average. 0 disables the alert.
+	LatencyAlertMultiple float64 `json:"latency_alert_multiple" yaml:"latency_alert_multiple"`
+
+	// Class grade changes of this many percentage points or less aren't
+	// notified, e.g. 0.5 skips 89.4% -> 89.6%. Letter grade changes always are.
+	MinDelta float64 `json:"min_delta" yaml:"min_delta"`
+
+	// Which grade changes to notify: "both" (default), "up" for increases only
+	// or "down" for drops only. Changes whose direction can't be told from the
+	// grades, and other kinds of changes, are always notified.
+	NotifyDirection string `json:"notify_direction" yaml:"notify_direction"`
+
+	// Append every class grade to HistoryFile (JSON lines) on each poll, and
+	// add a sparkline of the recent grades to grade changes. With
+	// HistoryTrendDays set, they also say how far the grade has moved over that
+	// many days. Records older than HistoryMaxAge are dropped (0 keeps them
+	// all). Empty HistoryFile disables the history.
+	HistoryFile      string   `json:"history_file" yaml:"history_file"`
+	HistoryTrendDays int      `json:"history_trend_days" yaml:"history_trend_days"`
+	HistoryMaxAge    Duration `json:"history_max_age" yaml:"history_max_age"`
+
+	// Notify when this many polls in a row fail to fetch from PowerSchool (e.g.
+	// after a password change), at most once per FetchFailureAlertCooldown.
+	// 0 disables the alert.
+	FetchFailureAlertAfter    int      `json:"fetch_failure_alert_after" yaml:"fetch_failure_alert_after"`
+	FetchFailureAlertCooldown Duration `json:"fetch_failure_alert_cooldown" yaml:"fetch_failure_alert_cooldown"`
+
+	// Stop polling PowerSchool for CircuitBreakerCooldown once this many polls
+	// in a row have failed, so a locked account isn't made worse. After the
+	// cooldown a single poll is tried again. 0 disables the breaker.
+	// CircuitBreakerNotify also notifies when polling pauses and resumes.
+	CircuitBreakerAfter    int      `json:"circuit_breaker_after" yaml:"circuit_breaker_after"`
+	CircuitBreakerCooldown Duration `json:"circuit_breaker_cooldown" yaml:"circuit_breaker_cooldown"`
+	CircuitBreakerNotify   bool     `json:"circuit_breaker_notify" yaml:"circuit_breaker_notify"`
+
+	// The school's grade scale: each letter grade's percentage range and GPA
+	// points, highest first. Defaults to the usual US scale with +/- grades.
+	// Classes whose grades don't fit it (pass/fail, ungraded) are left out of
+	// the GPA and threshold checks.
+	GradeScale []GradeBand `json:"grade_scale" yaml:"grade_scale"`
+
+	// Notify when the GPA moves by at least GPAAlertDelta points. 0 disables
+	// it. GPAScale overrides the grade scale's points for the letters it lists.
+	GPAAlertDelta float64            `json:"gpa_alert_delta" yaml:"gpa_alert_delta"`
+	GPAScale      map[string]float64 `json:"gpa_scale" yaml:"gpa_scale"`
+
+	// Credits per class, keyed by class name or section ID, for the weighted
+	// GPA. Classes not listed count as 1 credit.
+	ClassCredits map[string]float64 `json:"class_credits" yaml:"class_credits"`
+
+	// Send a separate alert when a class grade changes to below this percentage.
+	// 0 disables the alert. GradeAlertMention (e.g. "@here") is prepended to it.
+	GradeThreshold    float64 `json:"grade_threshold" yaml:"grade_threshold"`
+	GradeAlertMention string  `json:"grade_alert_mention" yaml:"grade_alert_mention"`
+
+	// Notify about new absences and tardies too
+	NotifyAttendance bool `json:"notify_attendance" yaml:"notify_attendance"`
+
+	// How many students on the account to compare at once. Each one's
+	// backups are kept separately, so they can safely run in parallel.
+	MaxConcurrentStudents int `json:"max_concurrent_students" yaml:"max_concurrent_students"`
+
+	// When a new reporting term starts, notify and, if NewTermReset is set,
+	// start a new baseline instead of reporting every class's grade reset.
+	NewTermReset bool `json:"new_term_reset" yaml:"new_term_reset"`
+
+	// Send a summary before the per-class changes when the number of enrolled classes changes
+	NotifyScheduleChanges bool `json:"notify_schedule_changes" yaml:"notify_schedule_changes"`
+
+	// Address (e.g. ":8080") to serve /healthz on, which fails once no poll has
+	// succeeded for HealthMaxAge (default three poll intervals). Empty disables it.
+	HealthAddr   string   `json:"health_addr" yaml:"health_addr"`
+	HealthMaxAge Duration `json:"health_max_age" yaml:"health_max_age"`
+
+	// Address to serve Prometheus metrics on at /metrics. Empty disables it.
+	MetricsAddr string `json:"metrics_addr" yaml:"metrics_addr"`
+
+	// OTLP/HTTP endpoint (e.g. "http://localhost:4318/v1/metrics") to push the
+	// same metrics to every OTLPInterval, with OTLPHeaders on each request.
+	// Empty disables it.
+	OTLPEndpoint string            `json:"otlp_endpoint" yaml:"otlp_endpoint"`
+	OTLPHeaders  map[string]string `json:"otlp_headers" yaml:"otlp_headers"`
+	OTLPInterval Duration          `json:"otlp_interval" yaml:"otlp_interval"`
+
+	// Recurring PowerSchool maintenance windows during which fetching is skipped
+	MaintenanceWindows []MaintenanceWindow `json:"maintenance_windows" yaml:"maintenance_windows"`
+}
+
+// Polling PowerSchool more often than this risks getting the account flagged.
+const minPollInterval = 30 * time.Second
+
+// Duration is a time.Duration written as a string like "10m" in config files.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// config is the active configuration, loaded once at startup.
+var config Config
+
+// location is the configured timezone, set from config.Timezone at startup.
+var location = time.Local
+
+// localNow returns the current time in the configured timezone.
+func localNow() time.Time {
+	return time.Now().In(location)
+}
+
+// calendarDate returns midnight of the day t falls on in the configured
+// timezone. PowerSchool sends plain dates as midnight UTC; those keep their
+// date instead of shifting to the day before in zones west of UTC.
+func calendarDate(t time.Time) time.Time {
+	utc := t.UTC()
+	if h, m, sec := utc.Clock(); h == 0 && m == 0 && sec == 0 {
+		year, month, day := utc.Date()
+		return time.Date(year, month, day, 0, 0, 0, 0, location)
+	}
+	year, month, day := t.In(location).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, location)
+}
+
+func defaultConfig() Config {
+	return Config{
+		PollInterval:                "15m",
+		PollJitter:                  10,
+		GradeScale:                  defaultGradeScale,
+		NewTermReset:                true,
+		MaxConcurrentStudents:       4,
+		MatrixHTML:                  true,
+		HTTPTimeout:                 Duration(30 * time.Second),
+		TermTitlePattern:            "^Q",
+		Notifier:                    "discord",
+		DiscordEmbeds:               true,
+		MessageTemplates:            defaultMessageTemplates,
+		WebhookMethod:               http.MethodPost,
+		WebhookBody:                 defaultWebhookBody,
+		NotifyMode:                  "immediate",
+		NotifyDirection:             "both",
+		DigestTime:                  "18:00",
+		DigestFile:                  "digest.json",
+		QuietHoursFile:              "quiet_hours_queue.json",
+		NotificationQueueFile:       "pending_notifications.json",
+		OTLPInterval:                Duration(time.Minute),
+		SMTPPort:                    587,
+		Storage:                     "json",
+		DatabaseFile:                "ps-diff.db",
+		StateFile:                   "state.json",
+		BackupClassesFile:           "backup_classes.json",
+		BackupAssignmentsFile:       "backup_assignments.json",
+		FallbackAfterRateLimit:      Duration(10 * time.Minute),
+		PrimaryRecoveryChecks:       3,
+		NotificationFailureCooldown: Duration(5 * time.Minute),
+		DedupeWindow:                Duration(time.Hour),
+		DedupeFile:                  "sent_notifications.json",
+		RecapSkipEmptyDays:          true,
+		RecapChangesFile:            "recap_changes.json",
+		RemindersFile:               "reminders.json",
+		HistoryMaxAge:               Duration(90 * 24 * time.Hour),
+		NotifyScheduleChanges:       true,
+		FetchFailureAlertAfter:      3,
+		FetchFailureAlertCooldown:   Duration(6 * time.Hour),
+		CircuitBreakerAfter:         5,
+		CircuitBreakerCooldown:      Duration(time.Hour),
+		CircuitBreakerNotify:        true,
+	}
+}
+
+// loadConfig builds the configuration from the defaults, the config file at
+// path (if any), and then environment variables, and validates the result.
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+
+	if path != "" {
+		if err := loadConfigFile(path, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+
+	for name, field := range map[string]*string{
+		"PS_URL":              &cfg.PowerSchoolURL,
+		"PS_USERNAME":         &cfg.PowerSchoolUsername,
+		"PS_PASSWORD":         &cfg.PowerSchoolPassword,
+		"PS_NOTIFIER":         &cfg.Notifier,
+		"DISCORD_WEBHOOK_URL": &cfg.DiscordWebhookURL,
+		"SLACK_WEBHOOK_URL":   &cfg.SlackWebhookURL,
+		"SMTP_PASSWORD":       &cfg.SMTPPassword,
+		"TELEGRAM_BOT_TOKEN":  &cfg.TelegramBotToken,
+		"NTFY_TOKEN":          &cfg.NtfyToken,
+		"MATRIX_ACCESS_TOKEN": &cfg.MatrixAccessToken,
+		"PUSHOVER_TOKEN":      &cfg.PushoverToken,
+		"PUSHOVER_USER":       &cfg.PushoverUser,
+		"PS_STATE_FILE":       &cfg.StateFile,
+		"PS_CLASSES_FILE":     &cfg.BackupClassesFile,
+		"PS_ASSIGNMENTS_FILE": &cfg.BackupAssignmentsFile,
+	} {
+		if value := os.Getenv(name); value != "" {
+			*field = value
+		}
+	}
+	if err := cfg.readSecretFiles(); err != nil {
+		return cfg, err
+	}
+
+	return cfg, cfg.validate()
+}
+
+// readSecretFiles fills in secrets from files, following the _FILE convention
+// of many container images: PS_PASSWORD_FILE=/run/secrets/ps_password is used
+// instead of PS_PASSWORD. An environment variable's file wins over the config
+// file's, and either wins over an inline value.
+func (cfg *Config) readSecretFiles() error {
+	for _, secret := range []struct {
+		env        string
+		configFile string
+		field      *string
+	}{
+		{"PS_PASSWORD", cfg.PowerSchoolPasswordFile, &cfg.PowerSchoolPassword},
+		{"DISCORD_WEBHOOK_URL", cfg.DiscordWebhookURLFile, &cfg.DiscordWebhookURL},
+		{"SLACK_WEBHOOK_URL", "", &cfg.SlackWebhookURL},
+		{"SMTP_PASSWORD", "", &cfg.SMTPPassword},
+		{"TELEGRAM_BOT_TOKEN", "", &cfg.TelegramBotToken},
+		{"NTFY_TOKEN", "", &cfg.NtfyToken},
+		{"MATRIX_ACCESS_TOKEN", "", &cfg.MatrixAccessToken},
+		{"PUSHOVER_TOKEN", "", &cfg.PushoverToken},
+		{"PUSHOVER_USER", "", &cfg.PushoverUser},
+	} {
+		path := os.Getenv(secret.env + "_FILE")
+		if path == "" {
+			path = secret.configFile
+		}
+		if path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading secret for %s: %w", secret.env, err)
+		}
+		*secret.field = strings.TrimSpace(string(data))
+	}
+	return nil
+}
+
+func loadConfigFile(path string, cfg *Config) error {
+	bytesData, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(bytesData, cfg)
+	case ".json":
+		err = json.Unmarshal(bytesData, cfg)
+	default:
+		return fmt.Errorf("unsupported config file type %q, expected .yaml, .yml or .json", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (cfg Config) validate() error {
+	var problems []string
+
+	if cfg.PowerSchoolURL == "" {
+		problems = append(problems, "powerschool_url (PS_URL) is required")
+	}
+	if cfg.PowerSchoolUsername == "" {
+		problems = append(problems, "powerschool_username (PS_USERNAME) is required")
+	}
+	if cfg.PowerSchoolPassword == "" {
+		problems = append(problems, "powerschool_password (PS_PASSWORD) is required")
+	}
+	if len(cfg.enabledNotifiers()) == 0 {
+		problems = append(problems, "at least one notifier must be enabled")
+	}
+	for _, name := range cfg.enabledNotifiers() {
+		problems = append(problems, cfg.validateNotifier(name)...)
+	}
+	switch cfg.NotifyMode {
+	case "immediate":
+	case "digest":
+		if _, err := time.Parse("15:04", cfg.DigestTime); err != nil {
+			problems = append(problems, fmt.Sprintf("digest_time %q must be HH:MM", cfg.DigestTime))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown notify_mode %q, expected immediate or digest", cfg.NotifyMode))
+	}
+	switch cfg.NotifyDirection {
+	case "both", "up", "down":
+	default:
+		problems = append(problems, fmt.Sprintf("unknown notify_direction %q, expected both, up or down", cfg.NotifyDirection))
+	}
+	if cfg.Storage != "json" && cfg.Storage != "sqlite" {
+		problems = append(problems, fmt.Sprintf("unknown storage %q, expected json or sqlite", cfg.Storage))
+	}
+	problems = append(problems, cfg.quietSchedule().validate()...)
+	for key, schedule := range cfg.studentQuietSchedules() {
+		for _, problem := range schedule.validate() {
+			problems = append(problems, fmt.Sprintf("student_settings %q: %s", key, problem))
+		}
+	}
+	problems = append(problems, cfg.MessageTemplates.validate()...)
+	for kind := range cfg.ChangeMarkers {
+		if _, ok := defaultChangeMarkers[kind]; !ok {
+			problems = append(problems, fmt.Sprintf("change_markers: unknown change %q, expected increase, decrease, new or removed", kind))
+		}
+	}
+	for class, webhookURL := range cfg.ClassWebhooks {
+		if err := validateWebhookURL(webhookURL); err != nil {
+			problems = append(problems, fmt.Sprintf("class_webhooks[%s]: %s", class, err))
+		}
+	}
+	if cfg.FallbackWebhookURL != "" {
+		if err := validateWebhookURL(cfg.FallbackWebhookURL); err != nil {
+			problems = append(problems, "fallback_webhook_url: "+err.Error())
+		}
+	}
+	if _, err := time.LoadLocation(cfg.Timezone); err != nil {
+		problems = append(problems, "timezone: "+err.Error())
+	}
+	if _, err := regexp.Compile(cfg.TermTitlePattern); err != nil {
+		problems = append(problems, "term_title_pattern: "+err.Error())
+	}
+	if interval, err := time.ParseDuration(cfg.PollInterval); err != nil {
+		problems = append(problems, "poll_interval: "+err.Error())
+	} else if cfg.DedupeWindow > 0 && time.Duration(cfg.DedupeWindow) < max(interval, minPollInterval) {
+		problems = append(problems, "dedupe_window must be at least poll_interval, or 0 to turn it off")
+	}
+	if cfg.ProxyURL != "" {
+		if u, err := url.Parse(cfg.ProxyURL); err != nil {
+			problems = append(problems, "proxy_url: "+err.Error())
+		} else if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" {
+			problems = append(problems, "proxy_url must be an http://, https:// or socks5:// URL")
+		}
+	}
+	for i, band := range cfg.GradeScale {
+		if band.Letter == "" {
+			problems = append(problems, fmt.Sprintf("grade_scale entry %d needs a letter", i+1))
+		}
+		if band.Max != 0 && band.Max < band.Min {
+			problems = append(problems, fmt.Sprintf("grade_scale %q: max is below min", band.Letter))
+		}
+	}
+	if cfg.HistoryMaxAge > 0 && time.Duration(cfg.HistoryTrendDays)*24*time.Hour > time.Duration(cfg.HistoryMaxAge) {
+		problems = append(problems, "history_max_age must cover history_trend_days")
+	}
+	if cfg.DedupeWindow < 0 {
+		problems = append(problems, "dedupe_window can't be negative")
+	}
+	if cfg.MaxConcurrentStudents < 1 {
+		problems = append(problems, "max_concurrent_students must be at least 1")
+	}
+	if cfg.PollJitter < 0 || cfg.PollJitter >= 100 {
+		problems = append(problems, "poll_jitter must be at least 0 and below 100")
+	}
+	if cfg.RecapTime != "" {
+		if _, err := time.Parse("15:04", cfg.RecapTime); err != nil {
+			problems = append(problems, fmt.Sprintf("recap_time %q must be HH:MM", cfg.RecapTime))
+		}
+	}
+	if cfg.OTLPEndpoint != "" {
+		if u, err := url.Parse(cfg.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, "otlp_endpoint must be an http:// or https:// URL")
+		}
+		if cfg.OTLPInterval <= 0 {
+			problems = append(problems, "otlp_interval must be positive")
+		}
+	}
+	for i, window := range cfg.MaintenanceWindows {
+		for _, problem := range window.validate() {
+			problems = append(problems, fmt.Sprintf("maintenance_windows entry %d: %s", i+1, problem))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// quietSchedule returns the global quiet hours, weekends and holidays.
+func (cfg Config) quietSchedule() QuietSchedule {
+	return QuietSchedule{Hours: cfg.QuietHours, Weekends: cfg.QuietWeekends, Holidays: cfg.Holidays}
+}
+
+// studentQuietSchedules returns each student_settings entry's schedule, with
+// the settings it leaves out taken from the global ones.
+func (cfg Config) studentQuietSchedules() map[string]QuietSchedule {
+	schedules := make(map[string]QuietSchedule, len(cfg.StudentSettings))
+	for key, settings := range cfg.StudentSettings {
+		schedule := cfg.quietSchedule()
+		if settings.QuietHours != nil {
+			schedule.Hours = *settings.QuietHours
+		}
+		if settings.QuietWeekends != nil {
+			schedule.Weekends = *settings.QuietWeekends
+		}
+		if settings.Holidays != nil {
+			schedule.Holidays = *settings.Holidays
+		}
+		schedules[key] = schedule
+	}
+	return schedules
+}
+
+// quietHoursEnabled reports whether notifications are ever held, for anyone.
+func (cfg Config) quietHoursEnabled() bool {
+	if cfg.quietSchedule().enabled() {
+		return true
+	}
+	for _, schedule := range cfg.studentQuietSchedules() {
+		if schedule.enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// enabledNotifiers returns the notifier names to send to: the Notifiers list
+// if set, otherwise the single Notifier.
+func (cfg Config) enabledNotifiers() []string {
+	if len(cfg.Notifiers) > 0 {
+		return cfg.Notifiers
+	}
+	if cfg.Notifier == "" {
+		return nil
+	}
+	return []string{cfg.Notifier}
+}
+
+// validateNotifier checks the settings a single notifier backend needs.
+func (cfg Config) validateNotifier(name string) []string {
+	var problems []string
+
+	switch name {
+	case "discord":
+		problems = append(problems, requireWebhookURL("discord_webhook_url (DISCORD_WEBHOOK_URL)", cfg.DiscordWebhookURL)...)
+	case "slack":
+		problems = append(problems, requireWebhookURL("slack_webhook_url (SLACK_WEBHOOK_URL)", cfg.SlackWebhookURL)...)
+	case "email":
+		if cfg.SMTPHost == "" {
+			problems = append(problems, "smtp_host is required")
+		}
+		if cfg.EmailFrom == "" {
+			problems = append(problems, "email_from is required")
+		}
+		if len(cfg.EmailTo) == 0 {
+			problems = append(problems, "email_to needs at least one address")
+		}
+	case "telegram":
+		if cfg.TelegramBotToken == "" {
+			problems = append(problems, "telegram_bot_token (TELEGRAM_BOT_TOKEN) is required")
+		}
+		if cfg.TelegramChatID == "" {
+			problems = append(problems, "telegram_chat_id is required")
+		}
+	case "ntfy":
+		problems = append(problems, requireWebhookURL("ntfy_url", cfg.NtfyURL)...)
+		switch strings.ToLower(cfg.NtfyPriority) {
+		case "", "1", "2", "3", "4", "5", "min", "low", "default", "high", "urgent", "max":
+		default:
+			problems = append(problems, fmt.Sprintf("ntfy_priority %q must be 1-5 or min, low, default, high or urgent", cfg.NtfyPriority))
+		}
+	case "pushover":
+		if cfg.PushoverToken == "" {
+			problems = append(problems, "pushover_token (PUSHOVER_TOKEN) is required")
+		}
+		if cfg.PushoverUser == "" {
+			problems = append(problems, "pushover_user (PUSHOVER_USER) is required")
+		}
+	case "webhook":
+		problems = append(problems, requireWebhookURL("webhook_url", cfg.WebhookURL)...)
+		if _, err := parseWebhookBody(cfg.WebhookBody); err != nil {
+			problems = append(problems, "webhook_body: "+err.Error())
+		}
+	case "matrix":
+		problems = append(problems, requireWebhookURL("matrix_homeserver_url", cfg.MatrixHomeserverURL)...)
+		if cfg.MatrixAccessToken == "" {
+			problems = append(problems, "matrix_access_token (MATRIX_ACCESS_TOKEN) is required")
+		}
+		if !strings.HasPrefix(cfg.MatrixRoomID, "!") {
+			problems = append(problems, "matrix_room_id must be a room ID like !abc123:example.org")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown notifier %q, expected discord, slack, email, telegram, ntfy, pushover, webhook or matrix", name))
+	}
+	return problems
+}
+
+// parsePollInterval parses a duration like "5m", raising it to minPollInterval
+// if it's too short.
+func parsePollInterval(raw string) (time.Duration, error) {
+	interval, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid poll interval %q: %w", raw, err)
+	}
+	if interval < minPollInterval {
+		logWarning(fmt.Sprintf("Poll interval %s is below the minimum of %s, using %s instead.",
+			interval, minPollInterval, minPollInterval))
+		interval = minPollInterval
+	}
+	return interval, nil
+}
+
+// proxyFunc returns the proxy selector for outgoing requests: proxy_url if
+// it's set, and the standard proxy environment variables otherwise.
+func (cfg Config) proxyFunc() func(*http.Request) (*url.URL, error) {
+	if cfg.ProxyURL == "" {
+		return http.ProxyFromEnvironment
+	}
+	// Already checked by validate
+	u, _ := url.Parse(cfg.ProxyURL)
+	return http.ProxyURL(u)
+}
+
+// jitteredInterval returns interval shifted by a random amount of up to
+// jitter percent either way.
+func jitteredInterval(interval time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	spread := float64(interval) * jitter / 100
+	return interval + time.Duration((rand.Float64()*2-1)*spread)
+}
+
+// requireWebhookURL returns a problem if the webhook URL is missing or invalid.
+func requireWebhookURL(name, raw string) []string {
+	if raw == "" {
+		return []string{name + " is required"}
+	}
+	if err := validateWebhookURL(raw); err != nil {
+		return []string{name + ": " + err.Error()}
+	}
+	return nil
+}
+
+func validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("%q is not a valid http(s) URL", raw)
+	}
+	return nil
+}
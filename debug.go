@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"ps-diff/powerschool"
+)
+
+// When set by -dump-student, each student's fetched data is written to this
+// file (with the student ID added) as indented JSON for inspection.
+var dumpStudentFile string
+
+// logStudentCounts logs how much data PowerSchool returned for a student, to
+// help tell a fetch problem apart from a filtering one.
+func logStudentCounts(student *powerschool.StudentDataVO) {
+	logDebug(fmt.Sprintf("Fetched %d sections, %d reporting terms, %d final grades, %d assignments and %d assignment scores for %s.",
+		len(student.Sections), len(student.ReportingTerms), len(student.FinalGrades),
+		len(student.Assignments), len(student.AssignmentScores), studentName(student)))
+}
+
+// dumpStudent writes student's raw data to its -dump-student file.
+func dumpStudent(student *powerschool.StudentDataVO) {
+	filename := studentFilePath(dumpStudentFile, student.StudentId)
+	data, err := json.MarshalIndent(student, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(filename, data)
+	}
+	if err != nil {
+		logWarning(fmt.Sprintf("Could not dump student data to %s: %s", filename, err))
+		return
+	}
+	logInfo("Dumped student data to " + filename + ".")
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DedupeNotifier drops each change line that was already sent within Window,
+// e.g. when a grade flips back and forth between polls, so a repeat isn't sent
+// again just because it's grouped with something new. A class heading is
+// dropped along with the last of its changes. When each line was last sent is
+// kept in File, keyed by a hash of the line and its heading, so a restart
+// doesn't send it again.
+type DedupeNotifier struct {
+	Notifier Notifier
+	Window   time.Duration
+	File     string
+
+	sent map[string]time.Time
+}
+
+func messageHash(message string) string {
+	sum := sha256.Sum256([]byte(message))
+	return hex.EncodeToString(sum[:])
+}
+
+// lineKeys returns the dedupe key for each line of message: a change line
+// under a class heading is keyed with the heading, other lines on their own.
+// Headings get an empty key, since they're sent only with their changes. A
+// message without class headings, like the recap, is kept whole: every line
+// gets the key of the entire message.
+func lineKeys(lines []MessageLine) []string {
+	keys := make([]string, len(lines))
+	if !slices.ContainsFunc(lines, func(line MessageLine) bool { return strings.HasPrefix(line.Text, changeIndent) }) {
+		key := messageHash(Message{Lines: lines}.String())
+		for i := range keys {
+			keys[i] = key
+		}
+		return keys
+	}
+	heading := ""
+	for i, line := range lines {
+		if strings.HasPrefix(line.Text, changeIndent) {
+			keys[i] = messageHash(heading + "\n" + line.Text)
+			continue
+		}
+		heading = line.Text
+		if i+1 < len(lines) && strings.HasPrefix(lines[i+1].Text, changeIndent) {
+			continue
+		}
+		keys[i] = messageHash(line.Text)
+	}
+	return keys
+}
+
+func loadSentMessages(filename string) map[string]time.Time {
+	sent := make(map[string]time.Time)
+
+	bytesData, err := os.ReadFile(filename)
+	if err != nil {
+		return sent
+	}
+	if err := json.Unmarshal(bytesData, &sent); err != nil {
+		logWarning("Could not parse sent notifications, starting a fresh list: " + err.Error())
+		return make(map[string]time.Time)
+	}
+	return sent
+}
+
+func saveSentMessages(filename string, sent map[string]time.Time) error {
+	bytesData, err := json.MarshalIndent(sent, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filename, bytesData)
+}
+
+// Notify sends the lines of message that weren't sent within the window.
+func (d *DedupeNotifier) Notify(ctx context.Context, message Message) error {
+	if len(message.Lines) == 0 {
+		return nil
+	}
+
+	if d.sent == nil {
+		d.sent = loadSentMessages(d.File)
+	}
+	now := time.Now()
+	for hash, sentAt := range d.sent {
+		// Forget messages outside the window so the file doesn't grow forever
+		if now.Sub(sentAt) >= d.Window {
+			delete(d.sent, hash)
+		}
+	}
+
+	var fresh Message
+	var freshKeys []string
+	var heading *MessageLine
+	keys := lineKeys(message.Lines)
+	for i, line := range message.Lines {
+		if keys[i] == "" {
+			heading = &message.Lines[i]
+			continue
+		}
+		if sentAt, ok := d.sent[keys[i]]; ok {
+			if i > 0 && keys[i] == keys[i-1] {
+				continue
+			}
+			logDebug(fmt.Sprintf("Skipping change identical to one sent %s ago: %q",
+				now.Sub(sentAt).Round(time.Second), truncateRunes(strings.TrimSpace(line.Text), 100)))
+			continue
+		}
+		if heading != nil && strings.HasPrefix(line.Text, changeIndent) {
+			fresh.Lines = append(fresh.Lines, *heading)
+		}
+		heading = nil
+		fresh.Lines = append(fresh.Lines, line)
+		freshKeys = append(freshKeys, keys[i])
+	}
+	if len(fresh.Lines) == 0 {
+		return nil
+	}
+
+	if err := d.Notifier.Notify(ctx, fresh); err != nil {
+		return err
+	}
+	for _, key := range freshKeys {
+		d.sent[key] = now
+	}
+	if err := saveSentMessages(d.File, d.sent); err != nil {
+		logError("Failed to save sent notifications: " + err.Error())
+	}
+	return nil
+}
+
+// Flush passes the poll on to the wrapped notifier.
+func (d *DedupeNotifier) Flush(ctx context.Context) {
+	flushNotifier(ctx, d.Notifier)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// stdoutNotifier prints notifications instead of sending them.
+type stdoutNotifier struct{}
+
+func (stdoutNotifier) Notify(ctx context.Context, message Message) error {
+	fmt.Println(message)
+	return nil
+}
+
+// diffBackupFiles compares two saved assignment backups and prints the
+// changes that would have been sent, without touching PowerSchool.
+func diffBackupFiles(oldFile, newFile string) error {
+	oldAssignments, err := loadBackupDataAssignments(oldFile)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", oldFile, err)
+	}
+	newAssignments, err := loadBackupDataAssignments(newFile)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", newFile, err)
+	}
+
+	return groupChanges(compareAssignments(oldAssignments, newAssignments)).notify(context.Background(), stdoutNotifier{})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// DigestNotifier collects notifications and sends them as a single message
+// once a day at Time (HH:MM). The buffer is persisted to File so changes
+// survive restarts.
+type DigestNotifier struct {
+	Notifier Notifier
+	Time     string
+	File     string
+}
+
+// DigestLog is the persisted digest buffer.
+type DigestLog struct {
+	Messages []Message
+	LastSent string
+}
+
+func loadDigestLog(filename string) DigestLog {
+	var digest DigestLog
+
+	bytesData, err := os.ReadFile(filename)
+	if err != nil {
+		return digest
+	}
+	if err := json.Unmarshal(bytesData, &digest); err != nil {
+		logWarning("Could not parse digest buffer, starting a fresh one: " + err.Error())
+		return DigestLog{}
+	}
+	return digest
+}
+
+func saveDigestLog(filename string, digest DigestLog) error {
+	bytesData, err := json.MarshalIndent(digest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filename, bytesData)
+}
+
+// Notify adds message to the digest buffer instead of sending it.
+func (d *DigestNotifier) Notify(ctx context.Context, message Message) error {
+	if len(message.Lines) == 0 {
+		return nil
+	}
+
+	digest := loadDigestLog(d.File)
+	digest.Messages = append(digest.Messages, message)
+	if err := saveDigestLog(d.File, digest); err != nil {
+		return fmt.Errorf("saving digest buffer: %w", err)
+	}
+	logInfo(fmt.Sprintf("Added to the daily digest (%d message(s) waiting).", len(digest.Messages)))
+	return nil
+}
+
+// Flush sends the buffered messages once today's digest time has passed.
+func (d *DigestNotifier) Flush(ctx context.Context) {
+	flushNotifier(ctx, d.Notifier)
+
+	at, err := time.Parse("15:04", d.Time)
+	if err != nil {
+		logError(fmt.Sprintf("Invalid digest time %q, expected HH:MM.", d.Time))
+		return
+	}
+	now := localNow()
+	digestAt := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	today := now.Format("2006-01-02")
+
+	digest := loadDigestLog(d.File)
+	if now.Before(digestAt) || digest.LastSent == today || len(digest.Messages) == 0 {
+		return
+	}
+
+	message := textMessage("Daily digest for " + today)
+	for _, queued := range digest.Messages {
+		message.Lines = append(message.Lines, queued.Lines...)
+	}
+	if err := d.Notifier.Notify(ctx, message); err != nil {
+		logError("Failed to send daily digest: " + err.Error())
+		return
+	}
+
+	digest.Messages = nil
+	digest.LastSent = today
+	if err := saveDigestLog(d.File, digest); err != nil {
+		logError("Failed to save digest buffer: " + err.Error())
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type WebhookMessage struct {
+	Content   string  `json:"content,omitempty"`
+	Embeds    []Embed `json:"embeds,omitempty"`
+	Username  string  `json:"username,omitempty"`
+	AvatarURL string  `json:"avatar_url,omitempty"`
+}
+
+// Embed is a Discord rich embed; see https://discord.com/developers/docs/resources/message#embed-object
+type Embed struct {
+	Title     string       `json:"title,omitempty"`
+	Color     int          `json:"color,omitempty"`
+	Fields    []EmbedField `json:"fields,omitempty"`
+	Timestamp string       `json:"timestamp,omitempty"`
+}
+
+type EmbedField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Delivery retries: webhookAttempts tries in total, starting at
+// webhookRetryDelay and doubling after each failure, plus jitter.
+const (
+	webhookAttempts   = 3
+	webhookRetryDelay = 2 * time.Second
+)
+
+// Discord limits
+const (
+	discordContentMax  = 2000
+	embedMaxFields     = 25
+	embedMaxChars      = 6000
+	embedFieldNameMax  = 256
+	embedFieldValueMax = 1024
+)
+
+// Embed sidebar colors
+const (
+	embedColorIncrease = 0x2ECC71
+	embedColorDecrease = 0xE74C3C
+	embedColorNeutral  = 0x5865F2
+)
+
+var errRateLimited = errors.New("webhook is rate-limited")
+
+// rateLimitError is returned for a 429 response, with how long Discord asked
+// us to wait before trying again.
+type rateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("%s (retry after %s)", errRateLimited, e.RetryAfter)
+}
+
+// retryDelay makes retry wait as long as Discord asked.
+func (e *rateLimitError) retryDelay() time.Duration {
+	return e.RetryAfter
+}
+
+func (e *rateLimitError) Is(target error) bool {
+	return target == errRateLimited
+}
+
+// Don't wait out rate limits longer than this inside a single delivery; leave
+// those to the notification queue's cooldown instead.
+const maxRateLimitWait = time.Minute
+
+// DiscordNotifier posts messages to a Discord webhook, as rich embeds with one
+// field per change if Embeds is set. If FallbackWebhookURL
+// is set, messages are routed there while the primary webhook stays
+// rate-limited for longer than FallbackAfter, until RecoveryChecks health
+// checks of the primary succeed in a row. Username and AvatarURL, if set,
+// override the webhook's default name and icon.
+type DiscordNotifier struct {
+	WebhookURL         string
+	Embeds             bool
+	Username           string
+	AvatarURL          string
+	FallbackWebhookURL string
+	FallbackAfter      time.Duration
+	RecoveryChecks     int
+
+	rateLimitedSince time.Time
+	usingFallback    bool
+	healthyChecks    int
+
+	// The message that last failed partway through, and how many of its
+	// chunks were delivered before it did.
+	partialMessage string
+	partialSent    int
+}
+
+func (d *DiscordNotifier) Notify(ctx context.Context, message Message) error {
+	payloads := d.buildPayloads(message)
+	if d.usingFallback {
+		return d.post(ctx, d.FallbackWebhookURL, message, payloads)
+	}
+
+	err := d.post(ctx, d.WebhookURL, message, payloads)
+	if d.recordPrimaryResult(err) {
+		return d.post(ctx, d.FallbackWebhookURL, message, payloads)
+	}
+	return err
+}
+
+// post sends the chunks of message that haven't been delivered yet. When one
+// fails, the chunks before it are remembered so that retrying the message
+// doesn't post them again.
+func (d *DiscordNotifier) post(ctx context.Context, url string, message Message, payloads []WebhookMessage) error {
+	if text := message.String(); d.partialMessage != text {
+		d.partialMessage, d.partialSent = text, 0
+	}
+	sent, err := postDiscordPayloads(ctx, url, payloads[d.partialSent:])
+	d.partialSent += sent
+	if err == nil {
+		d.partialMessage, d.partialSent = "", 0
+	}
+	return err
+}
+
+// Flush probes the primary webhook while the fallback is in use.
+func (d *DiscordNotifier) Flush(ctx context.Context) {
+	d.checkPrimaryHealth(ctx)
+}
+
+func (d *DiscordNotifier) buildPayloads(message Message) []WebhookMessage {
+	var payloads []WebhookMessage
+	if !d.Embeds {
+		for _, chunk := range splitMessage(message.String(), discordContentMax) {
+			payloads = append(payloads, WebhookMessage{Content: chunk})
+		}
+	} else {
+		for _, embed := range buildEmbeds(message.Lines) {
+			payloads = append(payloads, WebhookMessage{Embeds: []Embed{embed}})
+		}
+	}
+
+	for i := range payloads {
+		payloads[i].Username = d.Username
+		payloads[i].AvatarURL = d.AvatarURL
+	}
+	return payloads
+}
+
+// buildEmbeds turns the lines of a message into embed fields, starting a new
+// embed whenever Discord's field or size limits would be exceeded.
+func buildEmbeds(lines []MessageLine) []Embed {
+	var embeds []Embed
+	current := Embed{Title: "Grade Changes"}
+	size := len(current.Title)
+	increases, decreases := 0, 0
+
+	finish := func() {
+		switch {
+		case decreases > 0:
+			current.Color = embedColorDecrease
+		case increases > 0:
+			current.Color = embedColorIncrease
+		default:
+			current.Color = embedColorNeutral
+		}
+		current.Timestamp = time.Now().Format(time.RFC3339)
+		embeds = append(embeds, current)
+	}
+
+	for _, item := range embedItems(lines) {
+		field := item.field
+		fieldSize := len(field.Name) + len(field.Value)
+		if len(current.Fields) == embedMaxFields || size+fieldSize > embedMaxChars {
+			finish()
+			current = Embed{Title: "Grade Changes (continued)"}
+			size = len(current.Title)
+			increases, decreases = 0, 0
+		}
+		current.Fields = append(current.Fields, field)
+		size += fieldSize
+
+		switch item.direction {
+		case 1:
+			increases++
+		case -1:
+			decreases++
+		}
+	}
+	if len(current.Fields) > 0 {
+		finish()
+	}
+	return embeds
+}
+
+// embedItem is one embed field and which way the grades in it moved.
+type embedItem struct {
+	field     EmbedField
+	direction int
+}
+
+// embedItems makes one field per class for a class heading followed by its
+// indented changes (see formatChangeGroups), and one field per change for any
+// other line.
+func embedItems(lines []MessageLine) []embedItem {
+	var items []embedItem
+	for i := 0; i < len(lines); i++ {
+		line := lines[i].Text
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		var changes []string
+		direction := 0
+		for i+1 < len(lines) && strings.HasPrefix(lines[i+1].Text, changeIndent) {
+			i++
+			changes = append(changes, strings.TrimPrefix(lines[i].Text, changeIndent))
+			switch d := lines[i].Direction; {
+			case d < 0:
+				direction = -1
+			case d > 0 && direction == 0:
+				direction = 1
+			}
+		}
+		if len(changes) == 0 {
+			items = append(items, embedItem{embedFieldForChange(line), lines[i].Direction})
+			continue
+		}
+
+		name := truncateRunes(line, embedFieldNameMax)
+		for j, value := range splitMessage(strings.Join(changes, "\n"), embedFieldValueMax) {
+			if j > 0 {
+				name = truncateRunes(line+" (continued)", embedFieldNameMax)
+			}
+			items = append(items, embedItem{EmbedField{Name: name, Value: value}, direction})
+		}
+	}
+	return items
+}
+
+// embedFieldForChange splits a change like "Grade changed for Biology: 85 -> 90"
+// into a field named "Grade changed for Biology" with value "85 -> 90".
+func embedFieldForChange(line string) EmbedField {
+	name, value, found := strings.Cut(line, ": ")
+	if !found {
+		name, value = "Change", line
+	}
+	return EmbedField{
+		Name:  truncateRunes(name, embedFieldNameMax),
+		Value: truncateRunes(value, embedFieldValueMax),
+	}
+}
+
+func truncateRunes(s string, limit int) string {
+	if runes := []rune(s); len(runes) > limit {
+		return string(runes[:limit-1]) + "…"
+	}
+	return s
+}
+
+// postDiscordPayloads posts payloads in order, stopping at the first failure.
+// It returns how many were delivered.
+func postDiscordPayloads(ctx context.Context, url string, payloads []WebhookMessage) (int, error) {
+	for i, payload := range payloads {
+		if err := postDiscordWebhook(ctx, url, payload); err != nil {
+			return i, fmt.Errorf("posting chunk %d of %d: %w", i+1, len(payloads), err)
+		}
+	}
+	logSuccess(fmt.Sprintf("Discord notification sent in %d chunk(s)!", len(payloads)))
+	return len(payloads), nil
+}
+
+// postDiscordWebhook posts payload, retrying network errors, rate limits and
+// 5xx responses with exponential backoff.
+func postDiscordWebhook(ctx context.Context, url string, payload WebhookMessage) error {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshaling JSON: %w", err)
+	}
+
+	return retry(ctx, "Discord webhook", webhookAttempts, webhookRetryDelay, func() error {
+		err := postDiscordWebhookOnce(ctx, url, jsonData)
+		var rateLimit *rateLimitError
+		if err != nil && (!retryableWebhookError(err) ||
+			errors.As(err, &rateLimit) && rateLimit.RetryAfter > maxRateLimitWait) {
+			return stopRetrying(err)
+		}
+		return err
+	})
+}
+
+func postDiscordWebhookOnce(ctx context.Context, url string, jsonData []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &rateLimitError{RetryAfter: parseRetryAfter(resp)}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &webhookStatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(body))}
+	}
+	return nil
+}
+
+// parseRetryAfter reads how long to wait from a 429 response: the retry_after
+// field (in seconds) of Discord's JSON body, or else the Retry-After header.
+func parseRetryAfter(resp *http.Response) time.Duration {
+	var body struct {
+		RetryAfter float64 `json:"retry_after"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&body); err == nil && body.RetryAfter > 0 {
+		return time.Duration(body.RetryAfter * float64(time.Second))
+	}
+	if seconds, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	return 0
+}
+
+// webhookStatusError is returned when a webhook answers with a non-2xx status.
+type webhookStatusError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+// Is makes errors.Is(err, errRejected) true for 4xx responses, which sending
+// the same message again won't fix.
+func (e *webhookStatusError) Is(target error) bool {
+	return target == errRejected && e.StatusCode >= 400 && e.StatusCode < 500
+}
+
+func (e *webhookStatusError) Error() string {
+	if e.Body == "" {
+		return "webhook returned " + e.Status
+	}
+	return fmt.Sprintf("webhook returned %s: %s", e.Status, e.Body)
+}
+
+// retryableWebhookError reports whether a failed post is worth retrying:
+// network errors, rate limits and server errors are; other 4xx responses
+// (bad URL, bad payload) won't succeed on a retry.
+func retryableWebhookError(err error) bool {
+	var statusErr *webhookStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	return true
+}
+
+// recordPrimaryResult tracks how long the primary webhook has been rate-limited
+// and reports whether it just switched over to the fallback.
+func (d *DiscordNotifier) recordPrimaryResult(err error) bool {
+	if !errors.Is(err, errRateLimited) {
+		if err == nil {
+			d.rateLimitedSince = time.Time{}
+		}
+		return false
+	}
+
+	if d.rateLimitedSince.IsZero() {
+		d.rateLimitedSince = time.Now()
+		return false
+	}
+	if d.FallbackWebhookURL != "" && time.Since(d.rateLimitedSince) >= d.FallbackAfter {
+		logWarning(fmt.Sprintf("Primary webhook rate-limited for %s, switching to fallback webhook.",
+			time.Since(d.rateLimitedSince).Round(time.Second)))
+		d.usingFallback = true
+		d.healthyChecks = 0
+		return true
+	}
+	return false
+}
+
+// checkPrimaryHealth probes the primary webhook while the fallback is in use
+// and switches back after RecoveryChecks healthy responses in a row.
+func (d *DiscordNotifier) checkPrimaryHealth(ctx context.Context) {
+	if !d.usingFallback {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.WebhookURL, nil)
+	if err != nil {
+		d.healthyChecks = 0
+		return
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		if err == nil {
+			resp.Body.Close()
+		}
+		d.healthyChecks = 0
+		return
+	}
+	resp.Body.Close()
+
+	d.healthyChecks++
+	if d.healthyChecks >= d.RecoveryChecks {
+		logInfo("Primary webhook has recovered, switching back from fallback webhook.")
+		d.usingFallback = false
+		d.rateLimitedSince = time.Time{}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"html"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const emailSubject = "PowerSchool grade changes"
+
+// EmailNotifier sends messages as email through an SMTP server, with a plain
+// text body and an HTML table of the changes.
+type EmailNotifier struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (e *EmailNotifier) Notify(ctx context.Context, message Message) error {
+	body, err := buildEmail(e.From, e.To, emailSubject, message.String())
+	if err != nil {
+		return fmt.Errorf("building email: %w", err)
+	}
+
+	var auth smtp.Auth
+	if e.Username != "" {
+		auth = smtp.PlainAuth("", e.Username, e.Password, e.Host)
+	}
+	addr := net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	if err := sendMail(ctx, addr, e.Host, auth, e.From, e.To, body); err != nil {
+		return fmt.Errorf("sending email via %s: %w", addr, err)
+	}
+	logSuccess("Email notification sent!")
+	return nil
+}
+
+// buildEmail renders a multipart/alternative message with the changes as
+// plain text and as an HTML table, one row per line of message.
+// sendMail is smtp.SendMail with a deadline: the connection is bounded by
+// http_timeout and closed early if ctx is cancelled, so a stalled SMTP
+// server can't hang a poll.
+func sendMail(ctx context.Context, addr, host string, auth smtp.Auth, from string, to []string, body []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if httpClient.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(httpClient.Timeout))
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); ok {
+			if err := client.Auth(auth); err != nil {
+				return err
+			}
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func buildEmail(from string, to []string, subject, message string) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", writer.Boundary())
+
+	var table strings.Builder
+	table.WriteString("<table border=\"1\" cellpadding=\"4\" cellspacing=\"0\">\n")
+	for _, line := range strings.Split(message, "\n") {
+		fmt.Fprintf(&table, "<tr><td>%s</td></tr>\n", html.EscapeString(line))
+	}
+	table.WriteString("</table>\n")
+
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", message},
+		{"text/html; charset=utf-8", table.String()},
+	}
+	for _, part := range parts {
+		w, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
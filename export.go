@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"time"
+)
+
+// studentReport is one student's current classes, each with its assignments,
+// as written by the exports.
+type studentReport struct {
+	Name    string
+	Classes []classReport
+}
+
+type classReport struct {
+	Class
+	Assignments []Assignment
+}
+
+// fetchReports fetches the current data and applies the same term, class and
+// category filters as a poll. It doesn't read or write the backups.
+func fetchReports(ctx context.Context, fetcher StudentFetcher) ([]studentReport, error) {
+	students, _, err := fetchStudentsWithRetry(ctx, fetcher)
+	if err != nil {
+		return nil, fmt.Errorf("fetching student data: %w", err)
+	}
+
+	var reports []studentReport
+	for _, student := range students {
+		if !studentMonitored(student) {
+			continue
+		}
+		current, _, ok := currentStudentData(student)
+		if !ok {
+			continue
+		}
+		report := studentReport{Name: studentName(student)}
+		for _, class := range current.Classes {
+			cr := classReport{Class: class}
+			for _, assignment := range current.Assignments {
+				if assignment.ClassID == class.ID {
+					cr.Assignments = append(cr.Assignments, assignment)
+				}
+			}
+			report.Classes = append(report.Classes, cr)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// exportCSV fetches the current grades and writes them to filename as CSV: a
+// row per class with its grade, followed by a row per assignment in it. It
+// doesn't read or write the backups or send anything.
+func exportCSV(ctx context.Context, fetcher StudentFetcher, filename string) error {
+	reports, err := fetchReports(ctx, fetcher)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"student", "class", "class grade", "assignment", "score", "due date"})
+	for _, report := range reports {
+		for _, class := range report.Classes {
+			w.Write([]string{report.Name, class.Name, class.Grade, "", "", ""})
+			for _, assignment := range class.Assignments {
+				w.Write([]string{report.Name, class.Name, "", assignment.Name, assignment.Grade, assignment.DueDate.Format(time.DateOnly)})
+			}
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, buf.Bytes())
+}
+
+// gradeColor picks the CSS class a grade is colored with in the HTML report,
+// using the class percent when PowerSchool gives one.
+func gradeColor(grade string, percent float64) string {
+	value, ok := percent, percent > 0
+	if !ok {
+		value, ok = parseGrade(grade)
+	}
+	switch {
+	case !ok:
+		return "none"
+	case value >= 90:
+		return "a"
+	case value >= 80:
+		return "b"
+	case value >= 70:
+		return "c"
+	default:
+		return "f"
+	}
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"gradeColor": gradeColor,
+	"date":       func(t time.Time) string { return t.Format("Jan 2, 2006") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Grade report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+tr.class { background: #eee; font-weight: bold; }
+td.assignment { padding-left: 2em; }
+.a { color: #1a7f37; } .b { color: #4d8f00; } .c { color: #b08800; } .f { color: #cf222e; } .none { color: #666; }
+</style>
+</head>
+<body>
+<p>Generated {{date .Generated}}</p>
+{{range .Students}}<h1>{{.Name}}</h1>
+<table>
+<tr><th>Class / assignment</th><th>Grade</th><th>Due</th></tr>
+{{range .Classes}}<tr class="class"><td>{{.Name}}</td><td class="{{gradeColor .Grade .Percent}}">{{.Grade}}</td><td></td></tr>
+{{range .Assignments}}<tr><td class="assignment">{{.Name}}</td><td class="{{gradeColor .Grade 0}}">{{if .Grade}}{{.Grade}}{{else}}ungraded{{end}}</td><td>{{date .DueDate}}</td></tr>
+{{end}}{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+// exportHTML fetches the current grades and writes them to filename as a
+// printable HTML report, with each class's assignments listed under it. Like
+// exportCSV it's read-only.
+func exportHTML(ctx context.Context, fetcher StudentFetcher, filename string) error {
+	reports, err := fetchReports(ctx, fetcher)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	err = htmlReportTemplate.Execute(&buf, struct {
+		Generated time.Time
+		Students  []studentReport
+	}{localNow(), reports})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, buf.Bytes())
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+)
+
+// GradeBand is one letter grade in the school's grade scale: the percentages
+// it covers, from Min up to and including Max, and its GPA points. A Max of 0
+// means no upper limit, so extra credit still counts in the top band.
+type GradeBand struct {
+	Letter string  `json:"letter" yaml:"letter"`
+	Min    float64 `json:"min" yaml:"min"`
+	Max    float64 `json:"max" yaml:"max"`
+	Points float64 `json:"points" yaml:"points"`
+}
+
+// defaultGradeScale is the usual US scale with +/- grades on a 4.0 GPA scale.
+var defaultGradeScale = []GradeBand{
+	{Letter: "A+", Min: 97, Points: 4.0},
+	{Letter: "A", Min: 93, Max: 97, Points: 4.0},
+	{Letter: "A-", Min: 90, Max: 93, Points: 3.7},
+	{Letter: "B+", Min: 87, Max: 90, Points: 3.3},
+	{Letter: "B", Min: 83, Max: 87, Points: 3.0},
+	{Letter: "B-", Min: 80, Max: 83, Points: 2.7},
+	{Letter: "C+", Min: 77, Max: 80, Points: 2.3},
+	{Letter: "C", Min: 73, Max: 77, Points: 2.0},
+	{Letter: "C-", Min: 70, Max: 73, Points: 1.7},
+	{Letter: "D+", Min: 67, Max: 70, Points: 1.3},
+	{Letter: "D", Min: 63, Max: 67, Points: 1.0},
+	{Letter: "D-", Min: 60, Max: 63, Points: 0.7},
+	{Letter: "F", Min: 0, Max: 60, Points: 0},
+}
+
+// Grades already warned about for not fitting the grade scale, so the
+// warning isn't repeated every poll.
+var (
+	offScaleGradesMu sync.Mutex
+	offScaleGrades   = make(map[string]bool)
+)
+
+// letterBand returns the band for a letter grade like "B+".
+func letterBand(grade string) (GradeBand, bool) {
+	letter := strings.TrimSpace(grade)
+	for _, band := range config.GradeScale {
+		if strings.EqualFold(band.Letter, letter) {
+			return band, true
+		}
+	}
+	return GradeBand{}, false
+}
+
+// percentBand returns the first band in the scale that covers percent.
+func percentBand(percent float64) (GradeBand, bool) {
+	for _, band := range config.GradeScale {
+		if percent >= band.Min && (band.Max == 0 || percent <= band.Max) {
+			return band, true
+		}
+	}
+	return GradeBand{}, false
+}
+
+// gradeBand places a class in the grade scale by its letter grade, or by its
+// percentage when PowerSchool only gives a number. Grades that don't fit, like
+// pass/fail, are logged once and return false.
+func gradeBand(class Class) (GradeBand, bool) {
+	if band, ok := letterBand(class.Grade); ok {
+		return band, true
+	}
+	percent, ok := class.Percent, class.Percent > 0
+	if !ok {
+		percent, ok = parseGrade(class.Grade)
+	}
+	if ok {
+		if band, ok := percentBand(percent); ok {
+			return band, true
+		}
+	}
+	offScaleGradesMu.Lock()
+	defer offScaleGradesMu.Unlock()
+	if grade := classGrade(class); grade != "" && !offScaleGrades[grade] {
+		offScaleGrades[grade] = true
+		logWarning(fmt.Sprintf("Grade %q for %s doesn't fit the grade scale, leaving it out of GPA and threshold checks.", grade, class.Name))
+	}
+	return GradeBand{}, false
+}
+
+// gradePercent returns a class grade as a percentage for the threshold alert,
+// using the bottom of its band when there's only a letter grade.
+func gradePercent(class Class) (float64, bool) {
+	if value, ok := parseGrade(classGrade(class)); ok {
+		return value, true
+	}
+	band, ok := gradeBand(class)
+	return band.Min, ok
+}
+
+// gradePoints returns the GPA points for a class's grade, or false for
+// classes that don't count, like pass/fail or ungraded ones. GPAScale, if
+// set, overrides the points for the letters it lists.
+func gradePoints(class Class) (float64, bool) {
+	letter := strings.TrimSpace(class.Grade)
+	for grade, points := range config.GPAScale {
+		if strings.EqualFold(grade, letter) {
+			return points, true
+		}
+	}
+	band, ok := gradeBand(class)
+	return band.Points, ok
+}
+
+// classCredits returns the credits configured for a class in ClassCredits by
+// name (case-insensitive) or section ID, defaulting to 1.
+func classCredits(class Class) float64 {
+	for key, credits := range config.ClassCredits {
+		if classMatches(key, class.ID, class.Name) {
+			return credits
+		}
+	}
+	return 1
+}
+
+// computeGPA averages the grade points of every class that counts toward GPA,
+// both unweighted and weighted by each class's credits.
+func computeGPA(classes []Class) (unweighted, weighted float64, ok bool) {
+	total, counted := 0.0, 0
+	weightedTotal, credits := 0.0, 0.0
+	for _, class := range classes {
+		if points, ok := gradePoints(class); ok {
+			total += points
+			counted++
+			weightedTotal += points * classCredits(class)
+			credits += classCredits(class)
+		}
+	}
+	if counted == 0 {
+		return 0, 0, false
+	}
+	unweighted = total / float64(counted)
+	weighted = unweighted
+	if credits > 0 {
+		weighted = weightedTotal / credits
+	}
+	return unweighted, weighted, true
+}
+
+// notifyGPAChange notifies when the GPA, or the weighted GPA if ClassCredits
+// are configured, has moved by at least GPAAlertDelta.
+func notifyGPAChange(ctx context.Context, notifier Notifier, recapFile string, oldClasses, newClasses []Class) {
+	if config.GPAAlertDelta <= 0 {
+		return
+	}
+	oldGPA, oldWeighted, ok1 := computeGPA(oldClasses)
+	newGPA, newWeighted, ok2 := computeGPA(newClasses)
+	if !ok1 || !ok2 {
+		return
+	}
+	weighted := len(config.ClassCredits) > 0
+	moved := math.Abs(newGPA-oldGPA) >= config.GPAAlertDelta ||
+		(weighted && math.Abs(newWeighted-oldWeighted) >= config.GPAAlertDelta)
+	if !moved {
+		return
+	}
+
+	change := fmt.Sprintf("GPA changed: %.2f -> %.2f", oldGPA, newGPA)
+	if weighted {
+		change += fmt.Sprintf(" (weighted: %.2f -> %.2f)", oldWeighted, newWeighted)
+	}
+	recordRecapChanges(recapFile, []string{change})
+	line := MessageLine{Text: change}
+	switch {
+	case newGPA > oldGPA:
+		line.Direction = 1
+	case newGPA < oldGPA:
+		line.Direction = -1
+	}
+	if err := notifier.Notify(ctx, Message{Lines: []MessageLine{line}}); err != nil {
+		logError("Failed to send GPA change: " + err.Error())
+	}
+}
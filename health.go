@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// lastSuccessfulRun is when fetchAndCompare last finished, as Unix nanoseconds.
+// It's read by the status server's goroutine, hence atomic.
+var lastSuccessfulRun atomic.Int64
+
+// markRunSuccessful records that a poll just finished.
+func markRunSuccessful() {
+	lastSuccessfulRun.Store(time.Now().UnixNano())
+}
+
+// loadLastRun finds the most recent successful run recorded in any student's
+// saved state, so a restart can tell how long it's been.
+func loadLastRun() (time.Time, bool) {
+	var last time.Time
+	if backupDB != nil {
+		var savedAt string
+		err := backupDB.QueryRow(`SELECT COALESCE(MAX(saved_at), '') FROM snapshots WHERE kind = 'classes'`).Scan(&savedAt)
+		if err == nil && savedAt != "" {
+			last, err = time.Parse(time.RFC3339, savedAt)
+		}
+		return last, err == nil && !last.IsZero()
+	}
+
+	// Per-student state files look like state_1234.json; archives and other
+	// files that happen to match are skipped
+	ext := filepath.Ext(config.StateFile)
+	prefix := strings.TrimSuffix(config.StateFile, ext) + "_"
+	files, _ := filepath.Glob(suffixFilePath(config.StateFile, "*"))
+	for _, file := range files {
+		if _, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(file, prefix), ext), 10, 64); err != nil {
+			continue
+		}
+		state, err := loadState(file)
+		if err == nil && state.LastRun.After(last) {
+			last = state.LastRun
+		}
+	}
+	return last, !last.IsZero()
+}
+
+// healthHandler returns 200 while the last successful poll finished within
+// maxAge and 503 after that.
+func healthHandler(maxAge time.Duration) http.HandlerFunc {
+	started := time.Now()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		last := started
+		if nanos := lastSuccessfulRun.Load(); nanos != 0 {
+			last = time.Unix(0, nanos)
+		}
+		age := time.Since(last).Round(time.Second)
+		if age > maxAge {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "stale: last successful poll %s ago\n", age)
+			return
+		}
+		fmt.Fprintf(w, "ok: last successful poll %s ago\n", age)
+	}
+}
+
+// startStatusServers serves each path on its address in the background.
+// Paths on the same address share one server.
+func startStatusServers(routes map[string]map[string]http.Handler) {
+	for addr, paths := range routes {
+		mux := http.NewServeMux()
+		for path, handler := range paths {
+			mux.Handle(path, handler)
+			logInfo(fmt.Sprintf("Serving %s on %s.", path, addr))
+		}
+
+		go func() {
+			if err := http.ListenAndServe(addr, mux); err != nil {
+				logError(fmt.Sprintf("Status server on %s stopped: %s", addr, err))
+			}
+		}()
+	}
+}
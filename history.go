@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// GradeRecord is one class grade observed at one poll, stored one per line in
+// the history file.
+type GradeRecord struct {
+	Time      time.Time
+	StudentID int64
+	ClassID   int64
+	Class     string
+	Grade     string
+}
+
+// gradeHistoryMu serializes appends to the history file, which students
+// polled in parallel share, and guards lastHistoryPrune.
+var (
+	gradeHistoryMu   sync.Mutex
+	lastHistoryPrune time.Time
+)
+
+// appendGradeHistory records every class's current grade in the history file,
+// first dropping records older than config.HistoryMaxAge once a day.
+func appendGradeHistory(filename string, studentID int64, classes []Class) error {
+	gradeHistoryMu.Lock()
+	defer gradeHistoryMu.Unlock()
+	if config.HistoryMaxAge > 0 && time.Since(lastHistoryPrune) >= 24*time.Hour {
+		if err := pruneGradeHistory(filename, time.Now().Add(-time.Duration(config.HistoryMaxAge))); err != nil {
+			logWarning("Could not prune grade history: " + err.Error())
+		}
+		lastHistoryPrune = time.Now()
+	}
+
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	now := time.Now()
+	encoder := json.NewEncoder(file)
+	for _, class := range classes {
+		record := GradeRecord{Time: now, StudentID: studentID, ClassID: class.ID, Class: class.Name, Grade: classGrade(class)}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneGradeHistory rewrites the history file without the records from
+// before cutoff.
+func pruneGradeHistory(filename string, cutoff time.Time) error {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var kept bytes.Buffer
+	pruned := 0
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		var record GradeRecord
+		if json.Unmarshal(line, &record) == nil && record.Time.Before(cutoff) {
+			pruned++
+			continue
+		}
+		kept.Write(line)
+	}
+	if pruned == 0 {
+		return nil
+	}
+	logDebug(fmt.Sprintf("Pruning %d grade history records from before %s.", pruned, cutoff.Format(time.DateOnly)))
+	return writeFileAtomic(filename, kept.Bytes())
+}
+
+// loadGradeHistory reads the student's records from the history file, by
+// class ID, oldest first.
+func loadGradeHistory(filename string, studentID int64) (map[int64][]GradeRecord, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records := make(map[int64][]GradeRecord)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record GradeRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		if record.StudentID == studentID {
+			records[record.ClassID] = append(records[record.ClassID], record)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// addGradeTrends adds to each class grade change how far the grade has moved
+// over the last HistoryTrendDays days and a sparkline of its recent grades,
+// both from the student's grade history.
+func addGradeTrends(studentID int64, changes []Change) {
+	if config.HistoryFile == "" || !slices.ContainsFunc(changes, func(c Change) bool { return c.Kind == GradeChanged }) {
+		return
+	}
+	history, err := loadGradeHistory(config.HistoryFile, studentID)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			logWarning("Could not read grade history: " + err.Error())
+		}
+		return
+	}
+
+	for i, change := range changes {
+		if change.Kind != GradeChanged {
+			continue
+		}
+		records := history[change.ClassID]
+		if trend := describeGradeTrend(records); trend != "" {
+			changes[i].Detail += ", " + trend
+		}
+		if line := gradeSparkline(records, change.New); line != "" {
+			changes[i].Detail += " " + line
+		}
+	}
+}
+
+// gradeTrend returns how many points a class's grade has moved over the last
+// days days according to its records, or false if there isn't enough history.
+func gradeTrend(records []GradeRecord, days int) (float64, bool) {
+	since := time.Now().AddDate(0, 0, -days)
+	var first, last float64
+	found := 0
+	for _, record := range records {
+		if record.Time.Before(since) {
+			continue
+		}
+		value, ok := parseGrade(record.Grade)
+		if !ok {
+			continue
+		}
+		if found == 0 {
+			first = value
+		}
+		last = value
+		found++
+	}
+	if found < 2 {
+		return 0, false
+	}
+	return last - first, true
+}
+
+// describeGradeTrend formats a class's trend like "up 3 points this week", or
+// returns "" if history trends are disabled or there's nothing to say.
+func describeGradeTrend(records []GradeRecord) string {
+	if config.HistoryTrendDays <= 0 {
+		return ""
+	}
+	delta, ok := gradeTrend(records, config.HistoryTrendDays)
+	if !ok || delta == 0 {
+		return ""
+	}
+
+	direction := "up"
+	if delta < 0 {
+		direction = "down"
+	}
+	period := fmt.Sprintf("over %d days", config.HistoryTrendDays)
+	if config.HistoryTrendDays == 7 {
+		period = "this week"
+	}
+	return fmt.Sprintf("%s %g points %s", direction, math.Round(math.Abs(delta)*10)/10, period)
+}
+
+// gradeSparkline renders the class's last few distinct numeric grades, ending
+// with newGrade, or returns "" if newGrade isn't numeric or there are fewer
+// than three. Polls where the grade didn't move only count once.
+func gradeSparkline(records []GradeRecord, newGrade string) string {
+	newValue, ok := parseGrade(newGrade)
+	if !ok {
+		return ""
+	}
+
+	var values []float64
+	for _, record := range records {
+		if value, ok := parseGrade(record.Grade); ok && (len(values) == 0 || values[len(values)-1] != value) {
+			values = append(values, value)
+		}
+	}
+	// The dry run doesn't record the new grade
+	if len(values) == 0 || values[len(values)-1] != newValue {
+		values = append(values, newValue)
+	}
+	if len(values) > sparklineLength {
+		values = values[len(values)-sparklineLength:]
+	}
+	if len(values) < 3 {
+		return ""
+	}
+	return sparkline(values)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Recent successful fetch latencies, oldest first, and whether we've already
+// warned about the current slowdown.
+var (
+	recentFetchLatencies []time.Duration
+	fetchLatencyDegraded bool
+)
+
+// recordFetchLatency adds a fetch latency to the rolling window and, when
+// LatencyAlertMultiple is configured, notifies once when a fetch is that many times
+// slower than the baseline average.
+func recordFetchLatency(ctx context.Context, notifier Notifier, latency time.Duration) {
+	lastFetchLatency.Store(int64(latency))
+
+	var baseline time.Duration
+	for _, l := range recentFetchLatencies {
+		baseline += l
+	}
+	haveBaseline := len(recentFetchLatencies) >= latencyMinSamples
+	if haveBaseline {
+		baseline /= time.Duration(len(recentFetchLatencies))
+	}
+
+	recentFetchLatencies = append(recentFetchLatencies, latency)
+	if len(recentFetchLatencies) > latencyWindow {
+		recentFetchLatencies = recentFetchLatencies[len(recentFetchLatencies)-latencyWindow:]
+	}
+
+	if config.LatencyAlertMultiple <= 0 || !haveBaseline {
+		return
+	}
+
+	degraded := float64(latency) > float64(baseline)*config.LatencyAlertMultiple
+	if degraded && !fetchLatencyDegraded {
+		msg := fmt.Sprintf("PowerSchool is responding slowly: last fetch took %s (usual: %s).",
+			latency.Round(time.Millisecond), baseline.Round(time.Millisecond))
+		logWarning(msg)
+		if err := notifier.Notify(ctx, textMessage(msg)); err != nil {
+			logError("Failed to send latency alert: " + err.Error())
+		}
+	} else if !degraded && fetchLatencyDegraded {
+		logInfo(fmt.Sprintf("PowerSchool fetch latency back to normal (%s).", latency.Round(time.Millisecond)))
+	}
+	fetchLatencyDegraded = degraded
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ANSI escape sequences for colored logging:
+const (
+	ColorReset  = "\033[0m"
+	ColorRed    = "\033[31m"
+	ColorGreen  = "\033[32m"
+	ColorYellow = "\033[33m"
+	ColorCyan   = "\033[36m"
+	ColorGray   = "\033[90m"
+)
+
+// setupLogging installs the default slog logger. format is "text" for colored
+// lines meant for a terminal, or "json" for log aggregators.
+func setupLogging(level, format string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q, expected debug, info, warn or error", level)
+	}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "text":
+		handler = &colorHandler{out: os.Stdout, level: lvl, mu: &sync.Mutex{}}
+	case "json":
+		handler = slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: lvl})
+	default:
+		return fmt.Errorf("invalid log format %q, expected text or json", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// colorHandler prints each record as a single colored "[LEVEL] message" line
+// followed by any attributes.
+type colorHandler struct {
+	out   io.Writer
+	level slog.Level
+	attrs []slog.Attr
+	mu    *sync.Mutex
+}
+
+func (h *colorHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *colorHandler) Handle(_ context.Context, r slog.Record) error {
+	color, label := ColorCyan, "INFO"
+	switch {
+	case r.Level >= slog.LevelError:
+		color, label = ColorRed, "ERROR"
+	case r.Level >= slog.LevelWarn:
+		color, label = ColorYellow, "WARN"
+	case r.Level < slog.LevelInfo:
+		color, label = ColorGray, "DEBUG"
+	}
+
+	var sb strings.Builder
+	writeAttr := func(a slog.Attr) {
+		if a.Key == "status" && a.Value.String() == "success" {
+			color, label = ColorGreen, "SUCCESS"
+			return
+		}
+		fmt.Fprintf(&sb, " %s=%v", a.Key, a.Value)
+	}
+	for _, a := range h.attrs {
+		writeAttr(a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		writeAttr(a)
+		return true
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := fmt.Fprintf(h.out, "%s[%s] %s%s%s\n", color, label, r.Message, sb.String(), ColorReset)
+	return err
+}
+
+func (h *colorHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &clone
+}
+
+// Groups aren't used here, so attributes are just kept flat.
+func (h *colorHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// Secrets embedded in URLs: Discord and Slack webhook tokens, Telegram bot
+// tokens, user:password@ credentials and token-like query parameters.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(/api/webhooks/\d+/)[^/?\s"]+`),
+	regexp.MustCompile(`(hooks\.slack\.com/services/[^/\s]+/[^/\s]+/)[^/?\s"]+`),
+	regexp.MustCompile(`(/bot)[0-9]+:[A-Za-z0-9_-]+`),
+	regexp.MustCompile(`(://[^/:@\s]+:)[^@/\s]+(@)`),
+	regexp.MustCompile(`(?i)([?&](?:token|key|password|secret)=)[^&\s"]+`),
+}
+
+// redact replaces credentials and webhook tokens in msg with ***, so logs are
+// safe to paste into an issue.
+func redact(msg string) string {
+	for _, secret := range []string{config.PowerSchoolPassword, config.SMTPPassword, config.TelegramBotToken, config.NtfyToken, config.PushoverToken, config.MatrixAccessToken} {
+		if secret != "" {
+			msg = strings.ReplaceAll(msg, secret, "***")
+		}
+	}
+	for _, pattern := range secretPatterns {
+		msg = pattern.ReplaceAllStringFunc(msg, func(match string) string {
+			groups := pattern.FindStringSubmatch(match)
+			if len(groups) > 2 {
+				return groups[1] + "***" + groups[2]
+			}
+			return groups[1] + "***"
+		})
+	}
+	return msg
+}
+
+// ----- Logging Helpers -----
+// Every message goes through redact, since errors can embed request URLs.
+func logDebug(msg string) {
+	slog.Debug(redact(msg))
+}
+
+func logInfo(msg string) {
+	slog.Info(redact(msg))
+}
+
+func logWarning(msg string) {
+	slog.Warn(redact(msg))
+}
+
+func logSuccess(msg string) {
+	slog.Info(redact(msg), "status", "success")
+}
+
+func logError(msg string) {
+	slog.Error(redact(msg))
+}
@@ -2,160 +2,459 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
+	"io/fs"
+	"math"
 	"net/http"
 	"os"
-	// credit to @reteps on github for the powerschool package
-	"ps-diff/powerschool"
-	//
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
-)
 
-// ANSI escape sequences for colored logging:
-const (
-	ColorReset  = "\033[0m"
-	ColorRed    = "\033[31m"
-	ColorGreen  = "\033[32m"
-	ColorYellow = "\033[33m"
-	ColorCyan   = "\033[36m"
+	"ps-diff/powerschool"
 )
 
 type Class struct {
 	ID    int64
 	Name  string
 	Grade string
+
+	// Percentage from PowerSchool, 0 if the school doesn't report one
+	Percent float64
 }
 
 type Assignment struct {
-	ID        int64
-	Name      string
-	Grade     string
-	ClassID   int64
-	ClassName string
+	ID          int64
+	Name        string
+	Grade       string
+	ClassID     int64
+	ClassName   string
+	Category    string
+	DueDate     time.Time
+	Placeholder bool
+
+	// Raw points, when PowerSchool reports them. ScorePossible is 0 if unknown.
+	ScoreEarned   float64
+	ScorePossible float64
+
+	// The teacher's comment, "" if there is none. It's nil in backups from
+	// before comments were tracked, so those don't count as a change.
+	Comment *string `json:",omitempty"`
+
+	// The teacher's Missing and Late flags. It's nil in backups from before
+	// they were tracked, so those don't count as newly flagged.
+	Status *AssignmentStatus `json:",omitempty"`
 }
 
-type WebhookMessage struct {
-	Content string `json:"content"`
+// AssignmentStatus is the status flags PowerSchool shows on an assignment.
+type AssignmentStatus struct {
+	Missing bool `json:",omitempty"`
+	Late    bool `json:",omitempty"`
+}
+
+// flaggedChanges returns a change for each status flag set on newAssignment
+// that wasn't set in old.
+func flaggedChanges(old AssignmentStatus, newAssignment Assignment) []Change {
+	if newAssignment.Status == nil {
+		return nil
+	}
+	var changes []Change
+	for _, flag := range []struct {
+		kind     ChangeKind
+		old, new bool
+	}{
+		{MarkedMissing, old.Missing, newAssignment.Status.Missing},
+		{MarkedLate, old.Late, newAssignment.Status.Late},
+	} {
+		if flag.new && !flag.old {
+			changes = append(changes, Change{Kind: flag.kind, ClassID: newAssignment.ClassID,
+				ClassName: newAssignment.ClassName, Name: newAssignment.Name})
+		}
+	}
+	return changes
 }
 
 const (
-	powerschoolUrl      = "https://example.powerschool.com"
-	powerschoolUsername = "<YOUR_POWERSCHOOL_PARENT_USERNAME>"
-	powerschoolPassword = "<YOUR_POWERSCHOOL_PARENT_PASSWORD>"
-	discordWebhookURL   = "<YOUR_DISCORD_WEBHOOK_URL>"
+	// Number of recent grades shown in a class's trend sparkline
+	sparklineLength = 8
 
-	backupClassesFile     = "backup_classes.json"
-	backupAssignmentsFile = "backup_assignments.json"
+	// Rolling window of fetch latencies used as the baseline for latency alerts
+	latencyWindow     = 20
+	latencyMinSamples = 5
 )
 
-// ----- Colored Logging Helpers -----
-func logInfo(msg string) {
-	fmt.Printf("%s[INFO] %s%s\n", ColorCyan, msg, ColorReset)
-}
+// When set by -dry-run, notifications are logged instead of sent and no files are written.
+var dryRun bool
 
-func logWarning(msg string) {
-	fmt.Printf("%s[WARN] %s%s\n", ColorYellow, msg, ColorReset)
-}
+// ----- Backup/Restore Functions -----
 
-func logSuccess(msg string) {
-	fmt.Printf("%s[SUCCESS] %s%s\n", ColorGreen, msg, ColorReset)
-}
+// backupSchemaVersion is the version of the backup file format written by
+// saveState. Version 1 files are a bare JSON array of classes or assignments,
+// version 2 wraps one of those in an object recording the version, and version
+// 3 is the combined State file.
+const backupSchemaVersion = 3
 
-func logError(msg string) {
-	fmt.Printf("%s[ERROR] %s%s\n", ColorRed, msg, ColorReset)
+// State is everything remembered about one student between polls. It's saved
+// as a single file so classes and assignments can't get out of sync.
+type State struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	SavedAt       time.Time    `json:"savedAt"`
+	Classes       []Class      `json:"classes"`
+	Assignments   []Assignment `json:"assignments"`
+	// The reporting terms compared, to notice when a new one starts. Empty
+	// in backups from before terms were saved.
+	Terms []Term `json:"terms,omitempty"`
+	// When a poll last fetched and compared this student successfully
+	LastRun time.Time `json:"lastRun"`
+	// Absences and tardies, if notify_attendance is on. Null rather than
+	// empty when attendance wasn't tracked, so turning it on later starts a
+	// baseline instead of reporting every past absence.
+	Attendance []AttendanceRecord `json:"attendance"`
 }
 
-// ----- Backup/Restore Functions -----
-func loadBackupDataClasses(filename string) ([]Class, error) {
-	var classes []Class
+// Term is a reporting term selected by term_title_pattern or term_ids.
+type Term struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+}
 
-	file, err := os.Open(filename)
+func loadState(filename string) (State, error) {
+	var state State
+	bytesData, err := os.ReadFile(filename)
 	if err != nil {
-		// If file doesn't exist, return empty slice
-		return []Class{}, err
+		return state, err
+	}
+	if err := decodeBackup(bytesData, &state.SchemaVersion, &state, nil); err != nil {
+		return State{}, fmt.Errorf("reading %s: %w", filename, err)
 	}
-	defer file.Close()
+	return state, nil
+}
 
-	bytesData, err := io.ReadAll(file)
+// saveState writes state to filename atomically, stamped with the current
+// schema version and time.
+func saveState(filename string, state State) error {
+	state.SchemaVersion = backupSchemaVersion
+	state.SavedAt = time.Now()
+	bytesData, err := json.MarshalIndent(state, "", "  ")
 	if err != nil {
-		return []Class{}, err
+		return err
 	}
 
-	err = json.Unmarshal(bytesData, &classes)
+	return writeFileAtomic(filename, bytesData)
+}
+
+type classesBackup struct {
+	SchemaVersion int     `json:"schemaVersion"`
+	Classes       []Class `json:"classes"`
+}
+
+type assignmentsBackup struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	Assignments   []Assignment `json:"assignments"`
+}
+
+// loadBackupDataClasses reads the classes from a separate classes backup, or
+// from a State file.
+func loadBackupDataClasses(filename string) ([]Class, error) {
+	bytesData, err := os.ReadFile(filename)
 	if err != nil {
+		// If file doesn't exist, return empty slice
 		return []Class{}, err
 	}
 
-	return classes, nil
+	var backup classesBackup
+	if err := decodeBackup(bytesData, &backup.SchemaVersion, &backup, &backup.Classes); err != nil {
+		return []Class{}, fmt.Errorf("reading %s: %w", filename, err)
+	}
+	return backup.Classes, nil
 }
 
+// loadBackupDataAssignments reads the assignments from a separate assignments
+// backup, or from a State file.
 func loadBackupDataAssignments(filename string) ([]Assignment, error) {
-	var assignments []Assignment
-
-	file, err := os.Open(filename)
+	bytesData, err := os.ReadFile(filename)
 	if err != nil {
 		return []Assignment{}, err
 	}
-	defer file.Close()
 
-	bytesData, err := io.ReadAll(file)
-	if err != nil {
-		return []Assignment{}, err
+	var backup assignmentsBackup
+	if err := decodeBackup(bytesData, &backup.SchemaVersion, &backup, &backup.Assignments); err != nil {
+		return []Assignment{}, fmt.Errorf("reading %s: %w", filename, err)
 	}
+	return backup.Assignments, nil
+}
 
-	err = json.Unmarshal(bytesData, &assignments)
-	if err != nil {
-		return []Assignment{}, err
+// decodeBackup decodes a backup file of any known schema version, upgrading
+// it to the current shape. A version 1 file (a bare array) is decoded into
+// items, or rejected if items is nil; newer ones into backup, whose version
+// ends up in version.
+//
+// Fields added since version 1 (due date, category, points, comment) load as
+// their zero values, which the diff treats as unknown rather than changed, so
+// upgrading needs no further changes yet. Future migrations go here.
+func decodeBackup(data []byte, version *int, backup, items any) error {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if items == nil {
+			return errors.New("expected a state file, found a version 1 backup")
+		}
+		*version = 1
+		return json.Unmarshal(data, items)
 	}
 
-	return assignments, nil
+	if err := json.Unmarshal(data, backup); err != nil {
+		return err
+	}
+	if *version > backupSchemaVersion {
+		return fmt.Errorf("backup schema version %d is newer than this program supports (%d)", *version, backupSchemaVersion)
+	}
+	return nil
 }
 
-func saveBackupDataClasses(filename string, classes []Class) error {
-	bytesData, err := json.MarshalIndent(classes, "", "  ")
+// writeFileAtomic writes to a temp file next to filename and renames it into
+// place, so a crash mid-write never leaves a truncated backup behind.
+func writeFileAtomic(filename string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp*")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmp.Name())
 
-	return os.WriteFile(filename, bytesData, 0644)
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
 }
 
-func saveBackupDataAssignments(filename string, assignments []Assignment) error {
-	bytesData, err := json.MarshalIndent(assignments, "", "  ")
-	if err != nil {
-		return err
+// ----- Class and Assignment Filtering -----
+
+// classMatches reports whether a class_webhooks, class_credits or class filter
+// entry names the class, by name (case-insensitive) or section ID.
+func classMatches(entry string, classID int64, className string) bool {
+	return strings.EqualFold(entry, className) || entry == strconv.FormatInt(classID, 10)
+}
+
+// classMonitored reports whether a class passes the ignore_classes and
+// only_classes filters.
+func classMonitored(classID int64, className string) bool {
+	for _, ignored := range config.IgnoreClasses {
+		if classMatches(ignored, classID, className) {
+			return false
+		}
+	}
+	if len(config.OnlyClasses) == 0 {
+		return true
+	}
+	for _, only := range config.OnlyClasses {
+		if classMatches(only, classID, className) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterMonitoredClasses drops classes, and the assignments in them, that
+// classMonitored rejects. Backups can hold classes that were filtered out
+// later, and those shouldn't be reported as removed.
+func filterMonitoredClasses(classes []Class, assignments []Assignment) ([]Class, []Assignment) {
+	var keptClasses []Class
+	for _, class := range classes {
+		if classMonitored(class.ID, class.Name) {
+			keptClasses = append(keptClasses, class)
+		}
 	}
+	var keptAssignments []Assignment
+	for _, assignment := range assignments {
+		if classMonitored(assignment.ClassID, assignment.ClassName) {
+			keptAssignments = append(keptAssignments, assignment)
+		}
+	}
+	return keptClasses, keptAssignments
+}
 
-	return os.WriteFile(filename, bytesData, 0644)
+func categoryMonitored(category string) bool {
+	for _, ignored := range config.IgnoredCategories {
+		if strings.EqualFold(ignored, category) {
+			return false
+		}
+	}
+	if len(config.MonitoredCategories) == 0 {
+		return true
+	}
+	for _, monitored := range config.MonitoredCategories {
+		if strings.EqualFold(monitored, category) {
+			return true
+		}
+	}
+	return false
 }
 
-// ----- Discord Notifications -----
-func sendDiscordNotification(message string) {
-	if message == "" {
-		return
+func isPlaceholderGrade(grade string) bool {
+	for _, placeholder := range config.PlaceholderGrades {
+		if strings.TrimSuffix(placeholder, "%") == strings.TrimSuffix(grade, "%") {
+			return true
+		}
 	}
+	return false
+}
 
-	payload := WebhookMessage{Content: message}
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		logError("Error marshaling JSON: " + err.Error())
-		return
+// isStaleAssignment reports whether an assignment is too old to announce as new.
+func isStaleAssignment(assignment Assignment) bool {
+	if config.NewAssignmentMaxAgeDays <= 0 || assignment.DueDate.IsZero() {
+		return false
 	}
+	return time.Since(assignment.DueDate) > time.Duration(config.NewAssignmentMaxAgeDays)*24*time.Hour
+}
 
-	resp, err := http.Post(discordWebhookURL, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		logError("Error sending webhook: " + err.Error())
-		return
+// classGrade formats a class grade with both the letter and percentage when
+// the school reports both, e.g. "B+ (87.2%)", falling back to whichever it has.
+func classGrade(class Class) string {
+	if class.Percent == 0 {
+		return class.Grade
+	}
+	percent := strconv.FormatFloat(class.Percent, 'f', -1, 64) + "%"
+	if class.Grade == "" {
+		return percent
+	}
+	if _, ok := parseGrade(class.Grade); ok {
+		// The grade is already a number, so the letter is the only thing missing
+		return percent
 	}
-	defer resp.Body.Close()
-	logSuccess("Discord notification sent!")
+	return fmt.Sprintf("%s (%s)", class.Grade, percent)
 }
 
-func compareAssignmentsAndNotifyChanges(oldAssignments, newAssignments []Assignment) {
-	changes := []string{}
+// classGradeChanged reports whether a class's grade differs. Backups from
+// before percentages were tracked have no Percent, so only the grade counts.
+func classGradeChanged(oldClass, newClass Class) bool {
+	if oldClass.Grade != newClass.Grade {
+		return true
+	}
+	return oldClass.Percent != 0 && oldClass.Percent != newClass.Percent
+}
+
+// assignmentScore formats an assignment's grade with its points when known,
+// e.g. "18/20 (90%)".
+func assignmentScore(assignment Assignment) string {
+	if assignment.Grade == "" {
+		return "ungraded"
+	}
+	if assignment.ScorePossible <= 0 {
+		return assignment.Grade
+	}
+	return fmt.Sprintf("%s/%s (%s)",
+		strconv.FormatFloat(assignment.ScoreEarned, 'f', -1, 64),
+		strconv.FormatFloat(assignment.ScorePossible, 'f', -1, 64),
+		assignment.Grade)
+}
+
+// ----- Change Detection -----
+
+// classRoute returns the class_webhooks entry matching a class by name
+// (case-insensitive) or section ID, or "" for the default destination.
+func classRoute(classID int64, className string) string {
+	for route := range config.ClassWebhooks {
+		if classMatches(route, classID, className) {
+			return route
+		}
+	}
+	return ""
+}
+
+// routedChanges collects change lines by class route and, within a route, by
+// class, keeping the order routes and classes were first seen in.
+type routedChanges struct {
+	routes []string
+	groups map[string][]changeGroup
+}
+
+// changeGroup is the changes to one class.
+type changeGroup struct {
+	Class string
+	Lines []MessageLine
+}
+
+func (c *routedChanges) add(route, class string, line MessageLine) {
+	if c.groups == nil {
+		c.groups = make(map[string][]changeGroup)
+	}
+	groups, seen := c.groups[route]
+	if !seen {
+		c.routes = append(c.routes, route)
+	}
+	for i := range groups {
+		if groups[i].Class == class {
+			groups[i].Lines = append(groups[i].Lines, line)
+			return
+		}
+	}
+	c.groups[route] = append(groups, changeGroup{Class: class, Lines: []MessageLine{line}})
+}
+
+// all returns every change line, grouped by route and class.
+func (c *routedChanges) all() []string {
+	var all []string
+	for _, route := range c.routes {
+		for _, group := range c.groups[route] {
+			for _, line := range group.Lines {
+				all = append(all, line.Text)
+			}
+		}
+	}
+	return all
+}
+
+// notify sends one message per route, with the changes grouped by class.
+func (c *routedChanges) notify(ctx context.Context, notifier Notifier) error {
+	var errs []error
+	for _, route := range c.routes {
+		if err := notifyRoute(ctx, notifier, route, formatChangeGroups(c.groups[route])); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// formatChangeGroups writes each class's name followed by its changes,
+// indented by changeIndent. Notifiers that understand the layout, like the
+// Discord embeds, turn each class into its own section.
+func formatChangeGroups(groups []changeGroup) Message {
+	var message Message
+	for _, group := range groups {
+		message.Lines = append(message.Lines, MessageLine{Text: group.Class})
+		for _, line := range group.Lines {
+			line.Text = changeIndent + line.Text
+			message.Lines = append(message.Lines, line)
+		}
+	}
+	return message
+}
+
+// changeIndent marks a line as one of the changes under a class heading.
+const changeIndent = "  "
+
+// compareAssignments returns the changes between the old and new assignments.
+func compareAssignments(oldAssignments, newAssignments []Assignment) []Change {
+	var changes []Change
 	oldAssignmentMap := make(map[int64]Assignment)
 
 	for _, assignment := range oldAssignments {
@@ -163,173 +462,885 @@ func compareAssignmentsAndNotifyChanges(oldAssignments, newAssignments []Assignm
 	}
 
 	for _, newAssignment := range newAssignments {
+		change := func(kind ChangeKind, old, new string) Change {
+			return Change{Kind: kind, ClassID: newAssignment.ClassID, ClassName: newAssignment.ClassName,
+				Name: newAssignment.Name, Old: old, New: new}
+		}
+		// New work that's already flagged is announced even before it's graded
+		if _, exists := oldAssignmentMap[newAssignment.ID]; !exists && !isStaleAssignment(newAssignment) {
+			changes = append(changes, flaggedChanges(AssignmentStatus{}, newAssignment)...)
+		}
 		if oldAssignment, exists := oldAssignmentMap[newAssignment.ID]; exists {
 			if oldAssignment.Grade != newAssignment.Grade {
-				changes = append(changes, fmt.Sprintf(
-					"Grade changed for assignment '%s' in class %s: %s -> %s",
-					newAssignment.Name, newAssignment.ClassName, oldAssignment.Grade, newAssignment.Grade))
+				oldGrade := assignmentScore(oldAssignment)
+				if oldAssignment.Placeholder {
+					oldGrade += " (placeholder)"
+				}
+				if newAssignment.Placeholder {
+					logDebug(fmt.Sprintf("Assignment '%s' in class %s changed to placeholder grade %s, not notifying.",
+						newAssignment.Name, newAssignment.ClassName, newAssignment.Grade))
+				} else if oldAssignment.Grade == "" {
+					changes = append(changes, change(AssignmentGraded, "", assignmentScore(newAssignment)))
+				} else {
+					graded := change(AssignmentGradeChanged, oldGrade, assignmentScore(newAssignment))
+					graded.Delta = gradeDelta(oldAssignment.Grade, newAssignment.Grade)
+					changes = append(changes, graded)
+				}
+			}
+			if oldAssignment.Name != newAssignment.Name {
+				changes = append(changes, change(AssignmentRenamed, oldAssignment.Name, newAssignment.Name))
+			}
+			// Older backups have no due date or category, so only report real changes
+			if !oldAssignment.DueDate.IsZero() && !oldAssignment.DueDate.Equal(newAssignment.DueDate) {
+				changes = append(changes, change(DueDateChanged,
+					oldAssignment.DueDate.Format("2006-01-02"), newAssignment.DueDate.Format("2006-01-02")))
+			}
+			if oldAssignment.Comment != nil && newAssignment.Comment != nil && *oldAssignment.Comment != *newAssignment.Comment {
+				changes = append(changes, change(CommentChanged, *oldAssignment.Comment, *newAssignment.Comment))
+			}
+			if oldAssignment.Category != "" && oldAssignment.Category != newAssignment.Category {
+				changes = append(changes, change(CategoryChanged, oldAssignment.Category, newAssignment.Category))
+			}
+			if oldAssignment.Status != nil {
+				changes = append(changes, flaggedChanges(*oldAssignment.Status, newAssignment)...)
 			}
 			delete(oldAssignmentMap, newAssignment.ID)
-		} else {
-			changes = append(changes, fmt.Sprintf(
-				"New assignment added: '%s' in class %s with grade %s",
+		} else if isStaleAssignment(newAssignment) {
+			logDebug(fmt.Sprintf("Tracking old assignment '%s' (due %s) without announcing it.",
+				newAssignment.Name, newAssignment.DueDate.Format("2006-01-02")))
+		} else if newAssignment.Grade == "" {
+			logDebug(fmt.Sprintf("Tracking new ungraded assignment '%s' in class %s until it's graded.",
+				newAssignment.Name, newAssignment.ClassName))
+		} else if newAssignment.Placeholder {
+			logDebug(fmt.Sprintf("New assignment '%s' in class %s has placeholder grade %s, not notifying.",
 				newAssignment.Name, newAssignment.ClassName, newAssignment.Grade))
+		} else {
+			changes = append(changes, change(NewAssignment, "", assignmentScore(newAssignment)))
 		}
 	}
 
 	for _, deletedAssignment := range oldAssignmentMap {
-		changes = append(changes, fmt.Sprintf(
-			"Assignment removed: '%s' from class %s",
-			deletedAssignment.Name, deletedAssignment.ClassName))
+		changes = append(changes, Change{
+			Kind:      AssignmentRemoved,
+			ClassID:   deletedAssignment.ClassID,
+			ClassName: deletedAssignment.ClassName,
+			Name:      deletedAssignment.Name,
+			Old:       assignmentScore(deletedAssignment),
+		})
 	}
 
-	if len(changes) > 0 {
-		sendDiscordNotification(strings.Join(changes, "\n"))
-	} else {
+	if len(changes) == 0 {
 		logInfo("No changes in Assignments.")
 	}
+	return changes
 }
 
-func compareGradesAndNotifyChanges(oldClasses, newClasses []Class) {
-	changes := []string{}
-	oldGrades := make(map[int64]string)
+// compareGrades returns the changes between the old and new classes.
+func compareGrades(oldClasses, newClasses []Class) []Change {
+	var changes []Change
+	oldClassMap := make(map[int64]Class)
 
 	for _, class := range oldClasses {
-		oldGrades[class.ID] = class.Grade
+		oldClassMap[class.ID] = class
 	}
 
 	for _, class := range newClasses {
-		if oldGrade, exists := oldGrades[class.ID]; exists {
-			if oldGrade != class.Grade {
-				changes = append(changes, fmt.Sprintf(
-					"Grade changed for %s: %s -> %s",
-					class.Name, oldGrade, class.Grade))
+		oldClass, exists := oldClassMap[class.ID]
+		grade := classGrade(class)
+		if exists {
+			oldGrade := classGrade(oldClass)
+			changed := classGradeChanged(oldClass, class)
+			if changed && withinMinDelta(oldGrade, grade) {
+				logDebug(fmt.Sprintf("Grade for %s moved %s -> %s, within min_delta, not notifying.",
+					class.Name, oldGrade, grade))
+			} else if changed {
+				change := Change{Kind: GradeChanged, ClassID: class.ID, ClassName: class.Name,
+					Old: oldGrade, New: grade, Delta: gradeDelta(oldGrade, grade)}
+				changes = append(changes, change)
 			}
+			delete(oldClassMap, class.ID)
 		} else {
-			changes = append(changes, fmt.Sprintf(
-				"New class added: %s with grade %s",
-				class.Name, class.Grade))
+			changes = append(changes, Change{Kind: ClassAdded, ClassID: class.ID, ClassName: class.Name, New: grade})
 		}
 	}
 
-	if len(changes) > 0 {
-		sendDiscordNotification(strings.Join(changes, "\n"))
-	} else {
+	for _, removedClass := range oldClassMap {
+		changes = append(changes, Change{Kind: ClassRemoved, ClassID: removedClass.ID, ClassName: removedClass.Name,
+			Old: classGrade(removedClass)})
+	}
+
+	if len(changes) == 0 {
 		logInfo("No changes in Classes.")
 	}
+	return changes
+}
+
+// notifyLowGrades sends one alert for every class that's new or has changed
+// to below config.GradeThreshold.
+func notifyLowGrades(ctx context.Context, notifier Notifier, oldClasses, newClasses []Class) {
+	if config.GradeThreshold <= 0 {
+		return
+	}
+	oldClassMap := make(map[int64]Class)
+	for _, class := range oldClasses {
+		oldClassMap[class.ID] = class
+	}
+
+	var alerts []MessageLine
+	for _, class := range newClasses {
+		oldClass, exists := oldClassMap[class.ID]
+		if exists && !classGradeChanged(oldClass, class) {
+			continue
+		}
+		if value, ok := gradePercent(class); ok && value < config.GradeThreshold {
+			alerts = append(alerts, MessageLine{
+				Text:      fmt.Sprintf("⚠️ %s is below %g%%: %s", class.Name, config.GradeThreshold, classGrade(class)),
+				Direction: -1,
+			})
+		}
+	}
+
+	if len(alerts) > 0 {
+		message := Message{Lines: append([]MessageLine{{Text: "Low grade alert!"}}, alerts...)}
+		if config.GradeAlertMention != "" {
+			message = message.prefixed(config.GradeAlertMention + " ")
+		}
+		if err := notifier.Notify(ctx, message); err != nil {
+			logError("Failed to send low grade alert: " + err.Error())
+		}
+	}
+}
+
+// ----- Grade Trends -----
+var sparklineBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline renders values as a row of block characters scaled between the
+// smallest and largest value.
+func sparkline(values []float64) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	low, high := values[0], values[0]
+	for _, v := range values {
+		if v < low {
+			low = v
+		}
+		if v > high {
+			high = v
+		}
+	}
+
+	var sb strings.Builder
+	for _, v := range values {
+		level := len(sparklineBlocks) / 2
+		if high > low {
+			level = int((v - low) / (high - low) * float64(len(sparklineBlocks)-1))
+		}
+		sb.WriteRune(sparklineBlocks[level])
+	}
+	return sb.String()
+}
+
+var (
+	numericGradePattern  = regexp.MustCompile(`\d+(\.\d+)?`)
+	fractionGradePattern = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*/\s*(\d+(?:\.\d+)?)`)
+)
+
+// parseGrade pulls the numeric value out of grades like "93", "93.5%",
+// "A- (91%)" or "18/20", which counts as a percentage. Special grades with no
+// number, like "", "--", "Inc", "P" or "NG", aren't numeric, so callers only
+// compare them as text.
+func parseGrade(grade string) (float64, bool) {
+	grade = strings.TrimSpace(grade)
+	if match := fractionGradePattern.FindStringSubmatch(grade); match != nil {
+		earned, err1 := strconv.ParseFloat(match[1], 64)
+		possible, err2 := strconv.ParseFloat(match[2], 64)
+		if err1 != nil || err2 != nil || possible == 0 {
+			return 0, false
+		}
+		return earned / possible * 100, true
+	}
+
+	match := numericGradePattern.FindString(grade)
+	if match == "" {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(match, 64)
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+// withinMinDelta reports whether a grade change is too small to notify about
+// under config.MinDelta. Changes to the letter part of a grade, or where either
+// side isn't numeric, always count.
+func withinMinDelta(oldGrade, newGrade string) bool {
+	if config.MinDelta <= 0 {
+		return false
+	}
+	oldValue, ok1 := parseGrade(oldGrade)
+	newValue, ok2 := parseGrade(newGrade)
+	if !ok1 || !ok2 {
+		return false
+	}
+	oldLetter := strings.TrimSpace(numericGradePattern.ReplaceAllString(oldGrade, ""))
+	newLetter := strings.TrimSpace(numericGradePattern.ReplaceAllString(newGrade, ""))
+	if oldLetter != newLetter {
+		return false
+	}
+	return math.Abs(newValue-oldValue) <= config.MinDelta
+}
+
+// filterDirection drops grade changes going the other way from
+// config.NotifyDirection. Changes with no numeric direction are kept.
+func filterDirection(changes []Change) []Change {
+	if config.NotifyDirection == "" || config.NotifyDirection == "both" {
+		return changes
+	}
+	var kept []Change
+	for _, change := range changes {
+		if config.NotifyDirection == "up" && change.direction() < 0 ||
+			config.NotifyDirection == "down" && change.direction() > 0 {
+			logDebug(fmt.Sprintf("Not notifying %s, notify_direction is %s.", change, config.NotifyDirection))
+			continue
+		}
+		kept = append(kept, change)
+	}
+	return kept
 }
 
 // ----- The Main Logic -----
-func fetchAndCompare() {
-	logInfo("Starting data fetch and comparison...")
 
-	// Load old data from backup
-	oldClasses, err1 := loadBackupDataClasses(backupClassesFile)
-	oldAssignments, err2 := loadBackupDataAssignments(backupAssignmentsFile)
-	if err1 != nil {
-		logWarning("Could not load old classes, possibly first run.")
+// fetchAndCompare runs one poll, returning an error if PowerSchool couldn't be fetched.
+func fetchAndCompare(ctx context.Context, fetcher StudentFetcher, notifier Notifier) error {
+	if checkMaintenanceWindow() {
+		// Only the fetch waits: held and queued notifications don't need
+		// PowerSchool. The poll didn't run, so it isn't marked successful
+		flushNotifier(ctx, notifier)
+		return nil
 	}
-	if err2 != nil {
-		logWarning("Could not load old assignments, possibly first run.")
+
+	if !circuitAllowsFetch() {
+		return errCircuitOpen
 	}
 
+	logInfo("Starting data fetch and comparison...")
+	pollsTotal.Add(1)
+
+	// Retry anything left over from an earlier failed delivery
+	flushNotifier(ctx, notifier)
+
 	// Fetch new data
-	client := powerschool.Client(powerschoolUrl)
-	student, err := client.GetStudent(powerschoolUsername, powerschoolPassword)
+	students, latency, err := fetchStudentsWithRetry(ctx, fetcher)
 	if err != nil {
+		fetchFailuresTotal.Add(1)
+		var loginErr *powerschool.LoginError
+		if errors.As(err, &loginErr) {
+			loginFailuresTotal.Add(1)
+		}
 		logError("Failed to get student data: " + err.Error())
-		return
+		recordFetchFailure(ctx, notifier, err)
+		return err
 	}
+	recordFetchSuccess(ctx, notifier)
+	recordFetchLatency(ctx, notifier, latency)
 
-	// Build map for new data
-	idMap := make(map[int64]string)
-	for _, course := range student.Sections {
-		idMap[course.Id] = course.SchoolCourseTitle
+	var monitored []*powerschool.StudentDataVO
+	for _, student := range students {
+		if studentMonitored(student) {
+			monitored = append(monitored, student)
+		}
+	}
+	if len(monitored) == 0 {
+		logWarning(fmt.Sprintf("None of the %d students on this account match the students setting.", len(students)))
+	}
+
+	// Students are compared in parallel, up to MaxConcurrentStudents at a
+	// time. Their backups are separate files (or rows), but they share the
+	// notifier, so it's locked
+	shared := &lockedNotifier{Notifier: notifier}
+	limit := make(chan struct{}, max(config.MaxConcurrentStudents, 1))
+	var wg sync.WaitGroup
+	var errsMu sync.Mutex
+	var errs []error
+	for _, student := range monitored {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-limit }()
+			if err := pollStudent(ctx, student, shared, len(students), len(monitored)); err != nil {
+				errsMu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", studentName(student), err))
+				errsMu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		logError(fmt.Sprintf("Failed to send changes for %d of %d students: %s", len(errs), len(monitored), errors.Join(errs...)))
+	}
+
+	markRunSuccessful()
+	logInfo("Data fetch and comparison completed.")
+	return nil
+}
+
+// pollStudent compares one student's data against their backup and sends the
+// changes. accountStudents and monitoredStudents are how many students are on
+// the account and being compared, which decide file migration and prefixes.
+func pollStudent(ctx context.Context, student *powerschool.StudentDataVO, notifier Notifier, accountStudents, monitoredStudents int) error {
+	logStudentCounts(student)
+	if dumpStudentFile != "" {
+		dumpStudent(student)
+	}
+
+	stateFile := studentFilePath(config.StateFile, student.StudentId)
+	classesFile := studentFilePath(config.BackupClassesFile, student.StudentId)
+	assignmentsFile := studentFilePath(config.BackupAssignmentsFile, student.StudentId)
+	recapFile := studentFilePath(config.RecapChangesFile, student.StudentId)
+	remindersFile := studentFilePath(config.RemindersFile, student.StudentId)
+
+	// Backups from before multi-student support belong to the account's only student
+	if accountStudents == 1 {
+		if backupDB == nil {
+			classesFile = migrateLegacyFile(config.BackupClassesFile, classesFile)
+			assignmentsFile = migrateLegacyFile(config.BackupAssignmentsFile, assignmentsFile)
+		}
+		recapFile = migrateLegacyFile(config.RecapChangesFile, recapFile)
+	}
+
+	if key, ok := studentSettingsKey(student); ok {
+		ctx = withQuietStudent(ctx, key)
+	}
+	studentNotifier := notifier
+	if monitoredStudents > 1 {
+		studentNotifier = prefixNotifier{Notifier: notifier, Prefix: "[" + studentName(student) + "] "}
 	}
+	var store BackupStore = jsonBackupStore{
+		StateFile:       stateFile,
+		ClassesFile:     classesFile,
+		AssignmentsFile: assignmentsFile,
+		RemindersFile:   remindersFile,
+		KeepArchives:    config.BackupKeep,
+		MaxArchiveAge:   time.Duration(config.BackupMaxAge),
+	}
+	if backupDB != nil {
+		store = sqliteBackupStore{DB: backupDB, StudentID: student.StudentId}
+	}
+	// Everything that changed this poll goes out together, grouped by class
+	changes := compareStudent(ctx, student, studentNotifier, store, recapFile)
+	return groupChanges(changes).notify(ctx, studentNotifier)
+}
 
+// sectionNames resolves the section IDs used by final grades and assignments
+// to course titles. Some PowerSchool servers key those by the section's dcid
+// rather than its id, so both are tried.
+type sectionNames struct {
+	byID   map[int64]string
+	byDcid map[int64]string
+	warned map[int64]bool
+}
+
+func newSectionNames(sections []*powerschool.SectionVO) *sectionNames {
+	s := &sectionNames{byID: make(map[int64]string), byDcid: make(map[int64]string), warned: make(map[int64]bool)}
+	for _, section := range sections {
+		s.byID[section.Id] = section.SchoolCourseTitle
+		if section.Dcid != 0 {
+			s.byDcid[section.Dcid] = section.SchoolCourseTitle
+		}
+	}
+	return s
+}
+
+// name returns the course title for sectionID, falling back to a placeholder
+// (logged once) so notifications never show a blank class name.
+func (s *sectionNames) name(sectionID int64) string {
+	if name, ok := s.byID[sectionID]; ok && name != "" {
+		return name
+	}
+	if name, ok := s.byDcid[sectionID]; ok && name != "" {
+		return name
+	}
+	placeholder := fmt.Sprintf("Section %d", sectionID)
+	if !s.warned[sectionID] {
+		s.warned[sectionID] = true
+		logWarning(fmt.Sprintf("Could not find a course title for section %d, calling it %q.", sectionID, placeholder))
+	}
+	return placeholder
+}
+
+// currentStudentData picks out the current reporting terms, and the classes
+// and assignments in them that pass the class and category filters. The IDs
+// of assignments that were filtered out are returned too, so they can be
+// dropped from the backup before comparing. It returns false if no current
+// term matches term_title_pattern, or none of term_ids was fetched.
+func currentStudentData(student *powerschool.StudentDataVO) (State, map[int64]bool, bool) {
+	// Build map for new data
+	sections := newSectionNames(student.Sections)
+
+	termTitlePattern := regexp.MustCompile(config.TermTitlePattern)
 	allowedTerms := make(map[int64]bool)
-	termBeginDate, _ := time.Parse("2006-01-02", "2100-01-01")
-	termDueDate, _ := time.Parse("2006-01-02", "2000-01-01")
+	var selectedTerms []string
+	var terms []Term
+	// Term dates are whole days in the configured timezone, so termEndDate is
+	// the start of the day after the last term ends
+	var termBeginDate, termEndDate time.Time
+	today := calendarDate(localNow())
 	for _, reportingTerm := range student.ReportingTerms {
-		if time.Now().After(reportingTerm.StartDate) &&
-			time.Now().Before(reportingTerm.EndDate) &&
-			strings.HasPrefix(reportingTerm.Title, "Q") {
+		start := calendarDate(reportingTerm.StartDate)
+		end := calendarDate(reportingTerm.EndDate).AddDate(0, 0, 1)
+		selected := !today.Before(start) && today.Before(end) && termTitlePattern.MatchString(reportingTerm.Title)
+		if len(config.TermIDs) > 0 {
+			selected = slices.Contains(config.TermIDs, reportingTerm.Id)
+		}
+		if selected {
+			if len(allowedTerms) == 0 || end.After(termEndDate) {
+				termEndDate = end
+			}
+			if len(allowedTerms) == 0 || start.Before(termBeginDate) {
+				termBeginDate = start
+			}
 			allowedTerms[reportingTerm.Id] = true
-			if termDueDate.Before(reportingTerm.EndDate) {
-				termDueDate = reportingTerm.EndDate
+			selectedTerms = append(selectedTerms, reportingTerm.Title)
+			terms = append(terms, Term{ID: reportingTerm.Id, Title: reportingTerm.Title})
+		}
+	}
+	if len(config.TermIDs) > 0 {
+		for _, id := range config.TermIDs {
+			if !allowedTerms[id] {
+				logWarning(fmt.Sprintf("Reporting term %d from term_ids isn't in the fetched data.", id))
 			}
-			if termBeginDate.After(reportingTerm.StartDate) {
-				termBeginDate = reportingTerm.StartDate
+		}
+		if len(allowedTerms) == 0 {
+			logWarning("None of the term_ids were found, skipping comparison.")
+			return State{}, nil, false
+		}
+		logInfo("Using reporting terms from term_ids: " + strings.Join(selectedTerms, ", "))
+	} else if len(allowedTerms) == 0 {
+		var current []string
+		for _, reportingTerm := range student.ReportingTerms {
+			if !today.Before(calendarDate(reportingTerm.StartDate)) &&
+				today.Before(calendarDate(reportingTerm.EndDate).AddDate(0, 0, 1)) {
+				current = append(current, reportingTerm.Title)
 			}
 		}
+		// Comparing against nothing would report every class and assignment as removed
+		logWarning(fmt.Sprintf("No current reporting term matches term_title_pattern %q (current terms: %s), skipping comparison.",
+			config.TermTitlePattern, strings.Join(current, ", ")))
+		return State{}, nil, false
+	} else {
+		logInfo(fmt.Sprintf("Using reporting terms matching %q: %s", config.TermTitlePattern, strings.Join(selectedTerms, ", ")))
 	}
+	logDebug(fmt.Sprintf("Comparing assignments due from %s up to (not including) %s.",
+		termBeginDate.Format(time.DateOnly), termEndDate.Format(time.DateOnly)))
 
 	var newClasses []Class
 	for _, finalGrade := range student.FinalGrades {
-		if allowedTerms[finalGrade.ReportingTermId] {
+		if !allowedTerms[finalGrade.ReportingTermId] {
+			continue
+		}
+		if name := sections.name(finalGrade.Sectionid); classMonitored(finalGrade.Sectionid, name) {
 			newClasses = append(newClasses, Class{
-				ID:    finalGrade.Sectionid,
-				Name:  idMap[finalGrade.Sectionid],
-				Grade: finalGrade.Grade,
+				ID:      finalGrade.Sectionid,
+				Name:    name,
+				Grade:   finalGrade.Grade,
+				Percent: finalGrade.Percent,
 			})
 		}
 	}
 
-	assignmentScoreMap := make(map[int64]string)
+	scoreMap := make(map[int64]*powerschool.AssignmentScoreVO)
+	commentMap := make(map[int64]string)
+	statusMap := make(map[int64]AssignmentStatus)
 	for _, assignment := range student.AssignmentScores {
-		if assignment.Score != "" {
-			assignmentScoreMap[assignment.AssignmentId] = fmt.Sprintf("%s%%", assignment.Score)
-		}
+		commentMap[assignment.AssignmentId] = strings.TrimSpace(assignment.Comment)
+		statusMap[assignment.AssignmentId] = AssignmentStatus{Missing: assignment.Missing, Late: assignment.Late}
+		scoreMap[assignment.AssignmentId] = assignment
+	}
+
+	categoryMap := make(map[int64]string)
+	for _, category := range student.AssignmentCategories {
+		categoryMap[category.Id] = category.Name
 	}
 
 	var newAssignments []Assignment
+	excludedAssignments := make(map[int64]bool)
 	for _, assignment := range student.Assignments {
-		if assignment.DueDate.Before(termDueDate) && assignment.DueDate.After(termBeginDate) {
-			if _, exists := assignmentScoreMap[assignment.Id]; !exists {
+		if dueDate := calendarDate(assignment.DueDate); !dueDate.Before(termBeginDate) && dueDate.Before(termEndDate) {
+			category := categoryMap[int64(assignment.CategoryId)]
+			className := sections.name(assignment.Sectionid)
+			if !categoryMonitored(category) || !classMonitored(assignment.Sectionid, className) {
+				excludedAssignments[assignment.Id] = true
 				continue
 			}
-			className := ""
-			for _, class := range newClasses {
-				if class.ID == assignment.Sectionid {
-					className = class.Name
-					break
-				}
+			// Ungraded assignments are kept with an empty grade so the diff can
+			// tell when they get graded
+			grade, earned, hasPoints := assignmentGrade(scoreMap[assignment.Id], assignment.Pointspossible)
+			comment := commentMap[assignment.Id]
+			status := statusMap[assignment.Id]
+			newAssignment := Assignment{
+				ID:          assignment.Id,
+				Name:        assignment.Name,
+				Grade:       grade,
+				ClassID:     assignment.Sectionid,
+				ClassName:   className,
+				Category:    category,
+				DueDate:     assignment.DueDate,
+				Placeholder: grade != "" && isPlaceholderGrade(grade),
+				Comment:     &comment,
+				Status:      &status,
 			}
-			newAssignments = append(newAssignments, Assignment{
-				ID:        assignment.Id,
-				Name:      assignment.Name,
-				Grade:     assignmentScoreMap[assignment.Id],
-				ClassID:   assignment.Sectionid,
-				ClassName: className,
-			})
+			if hasPoints {
+				newAssignment.ScoreEarned = earned
+				newAssignment.ScorePossible = assignment.Pointspossible
+			}
+			newAssignments = append(newAssignments, newAssignment)
 		}
 	}
+	state := State{Classes: newClasses, Assignments: newAssignments, Terms: terms}
+	if config.NotifyAttendance {
+		state.Attendance = attendanceRecords(student, sections)
+	}
+	return state, excludedAssignments, true
+}
 
-	// Compare new vs. old
-	compareGradesAndNotifyChanges(oldClasses, newClasses)
-	compareAssignmentsAndNotifyChanges(oldAssignments, newAssignments)
+// assignmentGrade returns the percent grade for an assignment's score and,
+// when the assignment has points possible, the points earned. Score is the
+// points earned there, and the percent is PowerSchool's Percent or, if that's
+// missing, worked out from the points. Without points possible, Score is
+// taken as the percent.
+func assignmentGrade(score *powerschool.AssignmentScoreVO, pointsPossible float64) (grade string, earned float64, hasPoints bool) {
+	if score == nil {
+		return "", 0, false
+	}
+	percent := strings.TrimSuffix(strings.TrimSpace(score.Percent), "%")
+	earned, err := strconv.ParseFloat(strings.TrimSpace(score.Score), 64)
+	hasPoints = err == nil && pointsPossible > 0
+	switch {
+	case percent != "":
+		grade = percent + "%"
+	case hasPoints:
+		grade = strconv.FormatFloat(math.Round(earned/pointsPossible*1000)/10, 'f', -1, 64) + "%"
+	case score.Score != "":
+		grade = score.Score + "%"
+	}
+	return grade, earned, hasPoints
+}
 
-	// Save new data as old
-	if err := saveBackupDataClasses(backupClassesFile, newClasses); err != nil {
-		logError("Failed to backup new classes data: " + err.Error())
+// startedTerms returns the titles of terms in newTerms that weren't in
+// oldTerms. Backups without saved terms never report any, since there's
+// nothing to tell a new term apart from the first time terms are saved.
+func startedTerms(oldTerms, newTerms []Term) []string {
+	if len(oldTerms) == 0 {
+		return nil
 	}
-	if err := saveBackupDataAssignments(backupAssignmentsFile, newAssignments); err != nil {
-		logError("Failed to backup new assignments data: " + err.Error())
+	seen := make(map[int64]bool, len(oldTerms))
+	for _, term := range oldTerms {
+		seen[term.ID] = true
 	}
+	var started []string
+	for _, term := range newTerms {
+		if !seen[term.ID] {
+			started = append(started, term.Title)
+		}
+	}
+	return started
+}
 
-	logInfo("Data fetch and comparison completed.")
+// compareStudent compares one student's fresh data against their backup,
+// saves the new data as the backup and returns the grade and assignment
+// changes for the caller to send. Summaries, alerts, reminders and the recap
+// are sent to notifier directly.
+func compareStudent(ctx context.Context, student *powerschool.StudentDataVO, notifier Notifier, store BackupStore, recapFile string) []Change {
+	var changes []Change
+
+	// Load old data from backup
+	oldState, err := store.LoadState()
+	oldClasses, oldAssignments := oldState.Classes, oldState.Assignments
+	// With no backup yet there's nothing to diff against, so the first run
+	// only seeds the baseline instead of announcing everything as new
+	seeding := errors.Is(err, fs.ErrNotExist)
+	if seeding {
+		logInfo("No backup yet, first run: seeding baseline data without notifying.")
+	} else if err != nil {
+		logWarning("Could not load old data: " + err.Error())
+	}
+	oldClasses, oldAssignments = filterMonitoredClasses(oldClasses, oldAssignments)
+
+	newState, excludedAssignments, ok := currentStudentData(student)
+	if !ok {
+		return changes
+	}
+	newClasses, newAssignments := newState.Classes, newState.Assignments
+
+	// A new grading period resets every grade, so by default it starts a new
+	// baseline instead of being reported class by class
+	if started := startedTerms(oldState.Terms, newState.Terms); !seeding && len(started) > 0 {
+		message := "New grading period started: " + strings.Join(started, ", ")
+		if err := notifier.Notify(ctx, textMessage(message)); err != nil {
+			logError("Failed to send new grading period notice: " + err.Error())
+		}
+		if config.NewTermReset {
+			logInfo(message + ", starting a new baseline without comparing.")
+			seeding = true
+		}
+	}
+
+	// Drop excluded assignments from the old data too, so they aren't reported as removed
+	if len(excludedAssignments) > 0 {
+		var keptAssignments []Assignment
+		for _, assignment := range oldAssignments {
+			if !excludedAssignments[assignment.ID] {
+				keptAssignments = append(keptAssignments, assignment)
+			}
+		}
+		oldAssignments = keptAssignments
+	}
+
+	// Compare new vs. old, leading with a schedule summary if the class count changed
+	if config.NotifyScheduleChanges && !seeding && err == nil && len(oldClasses) != len(newClasses) {
+		summary := fmt.Sprintf("Schedule changed: %d -> %d classes", len(oldClasses), len(newClasses))
+		if err := notifier.Notify(ctx, textMessage(summary)); err != nil {
+			logError("Failed to send schedule change: " + err.Error())
+		}
+	}
+	recordLowestGrade(student.StudentId, newClasses)
+	if config.HistoryFile != "" && !dryRun {
+		if err := appendGradeHistory(config.HistoryFile, student.StudentId, newClasses); err != nil {
+			logError("Failed to record grade history: " + err.Error())
+		}
+	}
+	if !seeding {
+		gradeChanges := compareGrades(oldClasses, newClasses)
+		addGradeTrends(student.StudentId, gradeChanges)
+		changes = append(gradeChanges, compareAssignments(oldAssignments, newAssignments)...)
+		if config.NotifyAttendance && oldState.Attendance != nil {
+			changes = append(changes, compareAttendance(oldState.Attendance, newState.Attendance)...)
+		}
+		changes = filterDirection(changes)
+		// Missing and late work is more urgent than grade changes, so it leads
+		slices.SortStableFunc(changes, func(a, b Change) int {
+			switch {
+			case a.urgent() && !b.urgent():
+				return -1
+			case b.urgent() && !a.urgent():
+				return 1
+			}
+			return 0
+		})
+		recordRecapChanges(recapFile, groupChanges(changes).all())
+		notifyLowGrades(ctx, notifier, oldClasses, newClasses)
+		notifyGPAChange(ctx, notifier, recapFile, oldClasses, newClasses)
+	}
+	sendReminders(ctx, notifier, store, newAssignments)
+	sendRecapIfDue(ctx, notifier, recapFile, newClasses)
+
+	// Save new data as old
+	if dryRun {
+		logInfo(fmt.Sprintf("Dry run: would save %d classes and %d assignments to the backup.",
+			len(newClasses), len(newAssignments)))
+		return changes
+	}
+	newState.LastRun = time.Now()
+	if err := store.SaveState(newState); err != nil {
+		logError("Failed to backup new data: " + err.Error())
+	}
+	return changes
 }
 
 func main() {
-	// We'll run this check every 30 seconds
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
+	configPath := flag.String("config", "", "path to a YAML or JSON config file")
+	intervalFlag := flag.String("interval", "", "how often to poll PowerSchool, e.g. 15m or 1h (overrides the config file)")
+	once := flag.Bool("once", false, "fetch and compare once, then exit (non-zero if the fetch failed)")
+	logLevel := flag.String("log-level", "info", "minimum level to log: debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "log output format: text (colored) or json")
+	verbose := flag.Bool("verbose", false, "log at debug level, including counts of the fetched data and the selected term dates (same as -log-level debug)")
+	flag.StringVar(&dumpStudentFile, "dump-student", "", "write each student's fetched data as JSON to this file, with the student ID added to the name")
+	validateOnly := flag.Bool("validate", false, "check the config, poll interval, quiet hours, webhook URLs and that the backup paths are writable, print a report and exit, without contacting PowerSchool or notifying")
+	testNotify := flag.Bool("test-notify", false, "send a test message through the configured notifiers, then exit (non-zero if delivery failed)")
+	diff := flag.Bool("diff", false, "compare two assignment backups given as arguments (-diff old.json new.json), print the changes and exit")
+	exportFile := flag.String("export-csv", "", "fetch the current grades, write them to this CSV file and exit, without touching the backups or notifying")
+	htmlFile := flag.String("export-html", "", "fetch the current grades, write them to this file as an HTML report and exit, without touching the backups or notifying")
+	pidFile := flag.String("pidfile", "", "write the process ID to this file while running, for init systems that expect one")
+	flag.BoolVar(&dryRun, "dry-run", false, "fetch and compare, but only log the notifications and writes that would happen")
+	flag.Parse()
+
+	if *verbose {
+		*logLevel = "debug"
+	}
+	if err := setupLogging(*logLevel, *logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if *diff {
+		// Credentials aren't needed offline, so the config isn't validated
+		config = defaultConfig()
+		if *configPath != "" {
+			if err := loadConfigFile(*configPath, &config); err != nil {
+				logError(err.Error())
+				os.Exit(1)
+			}
+		}
+		if flag.NArg() != 2 {
+			logError("-diff needs two files: -diff old.json new.json")
+			os.Exit(2)
+		}
+		if err := diffBackupFiles(flag.Arg(0), flag.Arg(1)); err != nil {
+			logError(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *validateOnly {
+		if !validateSetup(os.Stdout, *configPath, *intervalFlag) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		logError(err.Error())
+		os.Exit(1)
+	}
+	config = cfg
+	if config.Timezone != "" {
+		// Already checked by validate
+		location, _ = time.LoadLocation(config.Timezone)
+	}
+
+	httpClient.Timeout = time.Duration(config.HTTPTimeout)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = config.proxyFunc()
+	httpClient.Transport = transport
+
+	// Cancel in-flight requests and notifications when asked to stop
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *testNotify {
+		if err := sendTestNotification(ctx, config); err != nil {
+			logError("Test notification failed: " + err.Error())
+			os.Exit(1)
+		}
+		logSuccess("Test notification sent.")
+		return
+	}
+
+	if *exportFile != "" || *htmlFile != "" {
+		export, filename := exportCSV, *exportFile
+		if *htmlFile != "" {
+			export, filename = exportHTML, *htmlFile
+		}
+		if err := export(ctx, newPowerSchoolSession(config), filename); err != nil {
+			logError("Export failed: " + err.Error())
+			os.Exit(1)
+		}
+		logSuccess("Exported current grades to " + filename + ".")
+		return
+	}
+
+	var notifier Notifier = dryRunNotifier{}
+	if !dryRun {
+		notifier, err = newNotifier(config)
+		if err != nil {
+			logError(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if config.Storage == "sqlite" {
+		backupDB, err = openBackupDB(config.DatabaseFile)
+		if err != nil {
+			logError("Failed to open backup database: " + err.Error())
+			os.Exit(1)
+		}
+		defer backupDB.Close()
+	}
+
+	if *pidFile != "" {
+		if err := writePIDFile(*pidFile); err != nil {
+			logError("Failed to write PID file: " + err.Error())
+			os.Exit(1)
+		}
+		defer removePIDFile(*pidFile)
+	}
+
+	if last, ok := loadLastRun(); ok {
+		logInfo(fmt.Sprintf("Last successful run was %s ago.", time.Since(last).Round(time.Second)))
+		lastSuccessfulRun.Store(last.UnixNano())
+	}
+
+	ps := newPowerSchoolSession(config)
+
+	if *once {
+		if err := fetchAndCompare(ctx, ps, notifier); err != nil {
+			// os.Exit skips the deferred cleanup
+			removePIDFile(*pidFile)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *intervalFlag != "" {
+		config.PollInterval = *intervalFlag
+	}
+	interval, err := parsePollInterval(config.PollInterval)
+	if err != nil {
+		logError(err.Error())
+		os.Exit(1)
+	}
+	if config.PollJitter > 0 {
+		logInfo(fmt.Sprintf("Polling PowerSchool every %s (give or take %g%%).", interval, config.PollJitter))
+	} else {
+		logInfo(fmt.Sprintf("Polling PowerSchool every %s.", interval))
+	}
+
+	routes := make(map[string]map[string]http.Handler)
+	if config.HealthAddr != "" {
+		maxAge := time.Duration(config.HealthMaxAge)
+		if maxAge == 0 {
+			maxAge = 3 * interval
+		}
+		routes[config.HealthAddr] = map[string]http.Handler{"/healthz": healthHandler(maxAge)}
+	}
+	if config.MetricsAddr != "" {
+		if routes[config.MetricsAddr] == nil {
+			routes[config.MetricsAddr] = make(map[string]http.Handler)
+		}
+		routes[config.MetricsAddr]["/metrics"] = http.HandlerFunc(metricsHandler)
+	}
+	startStatusServers(routes)
+	if config.OTLPEndpoint != "" {
+		exporter := &otlpExporter{
+			Endpoint: config.OTLPEndpoint,
+			Headers:  config.OTLPHeaders,
+			Interval: time.Duration(config.OTLPInterval),
+		}
+		go exporter.run(ctx)
+		logInfo(fmt.Sprintf("Exporting metrics to %s every %s.", config.OTLPEndpoint, exporter.Interval))
+	}
 
 	// Run it immediately once
-	fetchAndCompare()
+	fetchAndCompare(ctx, ps, notifier)
 
-	// Then run continuously on each tick
-	for range ticker.C {
-		fetchAndCompare()
+	// Then run continuously, re-arming the timer with a fresh jitter each
+	// time, until shut down
+	timer := time.NewTimer(jitteredInterval(interval, config.PollJitter))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			logInfo("Shutting down.")
+			return
+		case <-timer.C:
+			fetchAndCompare(ctx, ps, notifier)
+			timer.Reset(jitteredInterval(interval, config.PollJitter))
+		}
 	}
 }
@@ -0,0 +1,1745 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ps-diff/powerschool"
+)
+
+// fakeFetcher returns canned student data instead of calling PowerSchool.
+type fakeFetcher struct {
+	students []*powerschool.StudentDataVO
+	err      error
+	calls    int
+}
+
+func (f *fakeFetcher) GetStudents(ctx context.Context, username, password string) ([]*powerschool.StudentDataVO, error) {
+	f.calls++
+	return f.students, f.err
+}
+
+// capturingNotifier records every message instead of sending it.
+type capturingNotifier struct {
+	messages []string
+}
+
+func (c *capturingNotifier) Notify(ctx context.Context, message Message) error {
+	c.messages = append(c.messages, message.String())
+	return nil
+}
+
+// useTestConfig points the config at a temp directory for the test.
+func useTestConfig(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	saved := config
+	t.Cleanup(func() { config = saved })
+
+	config = defaultConfig()
+	config.StateFile = filepath.Join(dir, "state.json")
+	config.BackupClassesFile = filepath.Join(dir, "classes.json")
+	config.BackupAssignmentsFile = filepath.Join(dir, "assignments.json")
+	config.RecapChangesFile = filepath.Join(dir, "recap.json")
+	config.DedupeFile = filepath.Join(dir, "sent.json")
+	config.NotificationQueueFile = filepath.Join(dir, "pending.json")
+}
+
+// testStudent builds a student in a current quarter with one class and one
+// scored assignment.
+func testStudent(classGrade, assignmentScore string) *powerschool.StudentDataVO {
+	now := time.Now()
+	return &powerschool.StudentDataVO{
+		StudentId: 1,
+		Student:   &powerschool.StudentVO{FirstName: "Sam"},
+		Sections:  []*powerschool.SectionVO{{Id: 10, SchoolCourseTitle: "Biology"}},
+		ReportingTerms: []*powerschool.ReportingTermVO{
+			{Id: 100, Title: "Q1", StartDate: now.AddDate(0, 0, -30), EndDate: now.AddDate(0, 0, 30)},
+			{Id: 101, Title: "S1", StartDate: now.AddDate(0, 0, -30), EndDate: now.AddDate(0, 0, 30)},
+		},
+		FinalGrades: []*powerschool.FinalGradeVO{
+			{Sectionid: 10, ReportingTermId: 100, Grade: classGrade},
+			{Sectionid: 10, ReportingTermId: 101, Grade: "ignored"},
+		},
+		AssignmentCategories: []*powerschool.AsmtCatVO{{Id: 7, Name: "Quiz"}},
+		Assignments: []*powerschool.AssignmentVO{
+			{Id: 1000, Name: "Cells Quiz", Sectionid: 10, CategoryId: 7, DueDate: now.AddDate(0, 0, -1)},
+		},
+		AssignmentScores: []*powerschool.AssignmentScoreVO{{AssignmentId: 1000, Score: assignmentScore}},
+	}
+}
+
+func TestFetchAndCompareSeedsThenNotifies(t *testing.T) {
+	useTestConfig(t)
+	fetcher := &fakeFetcher{students: []*powerschool.StudentDataVO{testStudent("B", "80")}}
+	notifier := &capturingNotifier{}
+
+	if err := fetchAndCompare(context.Background(), fetcher, notifier); err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	if len(notifier.messages) != 0 {
+		t.Fatalf("first run should only seed the backups, got %q", notifier.messages)
+	}
+
+	fetcher.students = []*powerschool.StudentDataVO{testStudent("A", "95")}
+	if err := fetchAndCompare(context.Background(), fetcher, notifier); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+
+	if len(notifier.messages) != 1 {
+		t.Errorf("got %d messages, want the poll's changes in one: %q", len(notifier.messages), notifier.messages)
+	}
+	all := strings.Join(notifier.messages, "\n")
+	for _, want := range []string{
+		"Grade changed for Biology: B -> A",
+		"Grade changed for assignment 'Cells Quiz' in class Biology: 80% -> 95%",
+	} {
+		if !strings.Contains(all, want) {
+			t.Errorf("notifications %q missing %q", all, want)
+		}
+	}
+}
+
+func TestCompareStudentReturnsChanges(t *testing.T) {
+	useTestConfig(t)
+	store := jsonBackupStore{StateFile: config.StateFile}
+	notifier := &capturingNotifier{}
+
+	before, after := testStudent("B", "80"), testStudent("C", "80")
+	after.Assignments[0].DueDate = before.Assignments[0].DueDate
+
+	compareStudent(context.Background(), before, notifier, store, config.RecapChangesFile)
+	changes := compareStudent(context.Background(), after, notifier, store, config.RecapChangesFile)
+
+	if len(notifier.messages) != 0 {
+		t.Errorf("compareStudent sent %q, want the changes returned instead", notifier.messages)
+	}
+	if got, want := strings.Join(groupChanges(changes).all(), "\n"), "Grade changed for Biology: B -> C"; got != want {
+		t.Errorf("got changes %q, want %q", got, want)
+	}
+}
+
+func TestIgnoredClassesAreNotCompared(t *testing.T) {
+	useTestConfig(t)
+	store := jsonBackupStore{StateFile: config.StateFile}
+	notifier := &capturingNotifier{}
+
+	before, after := testStudent("B", "80"), testStudent("C", "60")
+	after.Assignments[0].DueDate = before.Assignments[0].DueDate
+
+	compareStudent(context.Background(), before, notifier, store, config.RecapChangesFile)
+	// Ignoring a class that's already in the backup must not report it removed
+	config.IgnoreClasses = []string{"biology"}
+	if changes := compareStudent(context.Background(), after, notifier, store, config.RecapChangesFile); len(changes) != 0 {
+		t.Errorf("got changes %q for an ignored class", groupChanges(changes).all())
+	}
+
+	config.IgnoreClasses = nil
+	for _, tc := range []struct {
+		only []string
+		want bool
+	}{
+		{nil, true},
+		{[]string{"10"}, true},
+		{[]string{"Chemistry"}, false},
+	} {
+		config.OnlyClasses = tc.only
+		if got := classMonitored(10, "Biology"); got != tc.want {
+			t.Errorf("only_classes %q: classMonitored = %v, want %v", tc.only, got, tc.want)
+		}
+	}
+}
+
+func TestClassNamesResolveMismatchedSectionIDs(t *testing.T) {
+	useTestConfig(t)
+	store := jsonBackupStore{StateFile: config.StateFile}
+
+	// Final grades and assignments refer to the section's dcid, and one grade
+	// is for a section that isn't listed at all
+	student := testStudent("B", "80")
+	student.Sections[0].Id, student.Sections[0].Dcid = 99, 10
+	student.FinalGrades = append(student.FinalGrades, &powerschool.FinalGradeVO{Sectionid: 11, ReportingTermId: 100, Grade: "A"})
+	compareStudent(context.Background(), student, &capturingNotifier{}, store, config.RecapChangesFile)
+
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, class := range state.Classes {
+		names = append(names, class.Name)
+	}
+	if got, want := strings.Join(names, ", "), "Biology, Section 11"; got != want {
+		t.Errorf("got classes %q, want %q", got, want)
+	}
+	if len(state.Assignments) != 1 || state.Assignments[0].ClassName != "Biology" {
+		t.Errorf("got assignments %+v, want one in Biology", state.Assignments)
+	}
+}
+
+func TestFetchAndCompareUnchangedDataIsQuiet(t *testing.T) {
+	useTestConfig(t)
+	fetcher := &fakeFetcher{students: []*powerschool.StudentDataVO{testStudent("B", "80")}}
+	notifier := &capturingNotifier{}
+
+	fetchAndCompare(context.Background(), fetcher, notifier)
+	fetchAndCompare(context.Background(), fetcher, notifier)
+
+	if len(notifier.messages) != 0 {
+		t.Errorf("unchanged data should not notify, got %q", notifier.messages)
+	}
+}
+
+var errFakeFetch = errors.New("PowerSchool is down")
+
+func TestFetchAndCompareRetriesFetchErrors(t *testing.T) {
+	useTestConfig(t)
+	saved := fetchRetryDelay
+	fetchRetryDelay = time.Millisecond
+	t.Cleanup(func() { fetchRetryDelay = saved })
+	fetcher := &fakeFetcher{err: errFakeFetch}
+
+	if err := fetchAndCompare(context.Background(), fetcher, &capturingNotifier{}); !errors.Is(err, errFakeFetch) {
+		t.Errorf("got error %v, want %v", err, errFakeFetch)
+	}
+	if fetcher.calls != fetchAttempts {
+		t.Errorf("fetched %d times, want %d", fetcher.calls, fetchAttempts)
+	}
+}
+
+func TestCircuitBreakerPausesPolls(t *testing.T) {
+	useTestConfig(t)
+	saved := fetchRetryDelay
+	fetchRetryDelay = time.Millisecond
+	t.Cleanup(func() {
+		fetchRetryDelay = saved
+		consecutiveFetchFailures, lastFetchFailureAlert = 0, time.Time{}
+		circuitOpen, circuitOpenUntil = false, time.Time{}
+	})
+	config.FetchFailureAlertAfter = 0
+	config.CircuitBreakerAfter = 2
+	fetcher := &fakeFetcher{err: errFakeFetch}
+	notifier := &capturingNotifier{}
+
+	fetchAndCompare(context.Background(), fetcher, notifier)
+	fetchAndCompare(context.Background(), fetcher, notifier)
+	if !circuitOpen {
+		t.Fatal("circuit should open after two failed polls")
+	}
+	calls := fetcher.calls
+	if err := fetchAndCompare(context.Background(), fetcher, notifier); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("got error %v while open, want %v", err, errCircuitOpen)
+	}
+	if fetcher.calls != calls {
+		t.Errorf("fetched %d more times while the circuit was open", fetcher.calls-calls)
+	}
+
+	// Once the cooldown is over a successful poll closes it again
+	circuitOpenUntil = time.Now()
+	fetcher.err, fetcher.students = nil, []*powerschool.StudentDataVO{testStudent("B", "80")}
+	if err := fetchAndCompare(context.Background(), fetcher, notifier); err != nil {
+		t.Fatal(err)
+	}
+	if circuitOpen {
+		t.Error("circuit should close after a successful poll")
+	}
+	if got, want := strings.Join(notifier.messages, "\n"), "Pausing PowerSchool polls for 1h0m0s after 2 failed polls in a row.\nPowerSchool polls resumed."; got != want {
+		t.Errorf("got notifications %q, want %q", got, want)
+	}
+}
+
+func TestFetchAndCompareDoesNotRetryRejectedLogin(t *testing.T) {
+	useTestConfig(t)
+	fetcher := &fakeFetcher{err: &powerschool.LoginError{Title: "Invalid login", Description: "bad password"}}
+	loginFailures := loginFailuresTotal.Load()
+
+	if err := fetchAndCompare(context.Background(), fetcher, &capturingNotifier{}); err == nil {
+		t.Error("expected an error")
+	}
+	if fetcher.calls != 1 {
+		t.Errorf("fetched %d times, want 1", fetcher.calls)
+	}
+	if got := loginFailuresTotal.Load() - loginFailures; got != 1 {
+		t.Errorf("counted %d failed logins, want 1", got)
+	}
+}
+
+func TestCompareAssignmentsAndNotifyChanges(t *testing.T) {
+	due := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+	comment := "Please redo question 3"
+	quiz := Assignment{ID: 1, Name: "Cells Quiz", Grade: "80%", ClassID: 10, ClassName: "Biology", Category: "Quiz", DueDate: due}
+
+	with := func(change func(*Assignment)) Assignment {
+		a := quiz
+		change(&a)
+		return a
+	}
+
+	tests := []struct {
+		name string
+		old  []Assignment
+		new  []Assignment
+		want []string
+	}{
+		{
+			name: "both empty",
+		},
+		{
+			name: "no change",
+			old:  []Assignment{quiz},
+			new:  []Assignment{quiz},
+		},
+		{
+			name: "new assignment",
+			new:  []Assignment{quiz},
+			want: []string{"New assignment added: 'Cells Quiz' in class Biology with grade 80%"},
+		},
+		{
+			name: "removed assignment",
+			old:  []Assignment{quiz},
+			want: []string{"Assignment removed: 'Cells Quiz' from class Biology"},
+		},
+		{
+			name: "grade change",
+			old:  []Assignment{quiz},
+			new:  []Assignment{with(func(a *Assignment) { a.Grade = "95%" })},
+			want: []string{"Grade changed for assignment 'Cells Quiz' in class Biology: 80% -> 95%"},
+		},
+		{
+			name: "grade change with points",
+			old:  []Assignment{with(func(a *Assignment) { a.ScoreEarned, a.ScorePossible = 16, 20 })},
+			new: []Assignment{with(func(a *Assignment) {
+				a.Grade = "90%"
+				a.ScoreEarned, a.ScorePossible = 18, 20
+			})},
+			want: []string{"Grade changed for assignment 'Cells Quiz' in class Biology: 16/20 (80%) -> 18/20 (90%)"},
+		},
+		{
+			name: "rename",
+			old:  []Assignment{quiz},
+			new:  []Assignment{with(func(a *Assignment) { a.Name = "Cell Structure Quiz" })},
+			want: []string{"Assignment renamed in Biology: 'Cells Quiz' -> 'Cell Structure Quiz'"},
+		},
+		{
+			name: "due date change",
+			old:  []Assignment{quiz},
+			new:  []Assignment{with(func(a *Assignment) { a.DueDate = due.AddDate(0, 0, 3) })},
+			want: []string{"Due date changed for 'Cells Quiz' in class Biology: 2024-10-01 -> 2024-10-04"},
+		},
+		{
+			name: "due date missing from old backup",
+			old:  []Assignment{with(func(a *Assignment) { a.DueDate = time.Time{} })},
+			new:  []Assignment{quiz},
+		},
+		{
+			name: "new ungraded assignment",
+			new:  []Assignment{with(func(a *Assignment) { a.Grade = "" })},
+		},
+		{
+			name: "ungraded assignment graded",
+			old:  []Assignment{with(func(a *Assignment) { a.Grade = "" })},
+			new:  []Assignment{quiz},
+			want: []string{"Assignment 'Cells Quiz' in class Biology was graded: 80%"},
+		},
+		{
+			name: "comment added",
+			old:  []Assignment{with(func(a *Assignment) { a.Comment = new(string) })},
+			new:  []Assignment{with(func(a *Assignment) { a.Comment = &comment })},
+			want: []string{"Teacher commented on 'Cells Quiz' in class Biology: \"Please redo question 3\""},
+		},
+		{
+			name: "comment missing from old backup",
+			old:  []Assignment{quiz},
+			new:  []Assignment{with(func(a *Assignment) { a.Comment = &comment })},
+		},
+		{
+			name: "new placeholder grade",
+			old:  []Assignment{quiz},
+			new:  []Assignment{with(func(a *Assignment) { a.Grade, a.Placeholder = "0%", true })},
+		},
+		{
+			name: "several changes in one message",
+			old:  []Assignment{quiz},
+			new: []Assignment{with(func(a *Assignment) {
+				a.Name = "Cell Structure Quiz"
+				a.Grade = "85%"
+			})},
+			want: []string{
+				"Grade changed for assignment 'Cell Structure Quiz' in class Biology: 80% -> 85%\n" +
+					"Assignment renamed in Biology: 'Cells Quiz' -> 'Cell Structure Quiz'",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestConfig(t)
+			got := groupChanges(compareAssignments(tt.old, tt.new)).all()
+
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got changes %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareGradesRoutesClassWebhooks(t *testing.T) {
+	useTestConfig(t)
+	config.ClassWebhooks = map[string]string{"biology": "https://discord.example/biology", "30": "https://discord.example/art"}
+	defaults, biology, art := &capturingNotifier{}, &capturingNotifier{}, &capturingNotifier{}
+	router := &RoutingNotifier{Default: defaults, Routes: map[string]Notifier{"biology": biology, "30": art}}
+
+	changes := compareGrades(
+		[]Class{{ID: 10, Name: "Biology", Grade: "B"}, {ID: 20, Name: "History", Grade: "B"}, {ID: 30, Name: "Art", Grade: "B"}},
+		[]Class{{ID: 10, Name: "Biology", Grade: "A"}, {ID: 20, Name: "History", Grade: "C"}, {ID: 30, Name: "Art", Grade: "A"}})
+	if err := groupChanges(changes).notify(context.Background(), router); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		notifier *capturingNotifier
+		want     string
+	}{
+		{"biology", biology, "Biology\n  Grade changed for Biology: B -> A"},
+		{"art", art, "Art\n  Grade changed for Art: B -> A"},
+		{"default", defaults, "History\n  Grade changed for History: B -> C"},
+	} {
+		if got := strings.Join(tt.notifier.messages, "\n"); got != tt.want {
+			t.Errorf("%s got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseGrade(t *testing.T) {
+	tests := []struct {
+		grade string
+		want  float64
+		ok    bool
+	}{
+		{"93", 93, true},
+		{"93.5%", 93.5, true},
+		{" 88 % ", 88, true},
+		{"A- (91%)", 91, true},
+		{"B+ (87.2%)", 87.2, true},
+		{"18/20", 90, true},
+		{"18/20 (90%)", 90, true},
+		{"0", 0, true},
+		{"", 0, false},
+		{"--", 0, false},
+		{"Inc", 0, false},
+		{"P", 0, false},
+		{"NG", 0, false},
+		{"B+", 0, false},
+		{"5/0", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseGrade(tt.grade)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseGrade(%q) = %v, %v; want %v, %v", tt.grade, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSpecialGradesStillReportTextChanges(t *testing.T) {
+	useTestConfig(t)
+	config.MinDelta = 5
+	config.GradeThreshold = 70
+	changes := compareGrades(
+		[]Class{{ID: 1, Name: "Art", Grade: "Inc"}, {ID: 2, Name: "Gym", Grade: "P"}, {ID: 3, Name: "Band", Grade: ""}},
+		[]Class{{ID: 1, Name: "Art", Grade: "B"}, {ID: 2, Name: "Gym", Grade: "NG"}, {ID: 3, Name: "Band", Grade: "--"}})
+
+	want := "Grade changed for Art: Inc -> B\nGrade changed for Gym: P -> NG\nGrade changed for Band:  -> --"
+	if got := strings.Join(groupChanges(changes).all(), "\n"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMessageTemplates(t *testing.T) {
+	useTestConfig(t)
+	config.MessageTemplates.GradeChanged = "{{.ClassName}}/{{.Name}}: {{.OldGrade}} => {{.NewGrade}}"
+	config.MessageTemplates.AssignmentRemoved = "{{.Missing}}"
+
+	changes := compareAssignments(
+		[]Assignment{
+			{ID: 1, Name: "Quiz", Grade: "80", ClassName: "Bio"},
+			{ID: 2, Name: "Lab", Grade: "90", ClassName: "Bio"},
+		},
+		[]Assignment{
+			{ID: 1, Name: "Quiz", Grade: "85", ClassName: "Bio"},
+			{ID: 3, Name: "Essay", Grade: "A", ClassName: "English"},
+		})
+
+	want := "Bio/Quiz: 80 => 85\n" +
+		"Assignment removed: 'Lab' from class Bio\n" +
+		"New assignment added: 'Essay' in class English with grade A"
+	if got := strings.Join(groupChanges(changes).all(), "\n"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	config.MessageTemplates.NewAssignment = "{{.Name"
+	if problems := config.MessageTemplates.validate(); len(problems) != 1 {
+		t.Errorf("validate() = %q, want one problem", problems)
+	}
+}
+
+func TestAssignmentMarkedMissingOrLate(t *testing.T) {
+	useTestConfig(t)
+	due := time.Now()
+	assignment := func(status *AssignmentStatus) Assignment {
+		return Assignment{ID: 1, Name: "Essay", ClassID: 1, ClassName: "English", DueDate: due, Status: status}
+	}
+
+	tests := []struct {
+		name     string
+		old, new *AssignmentStatus
+		want     []string
+	}{
+		{"newly missing", &AssignmentStatus{}, &AssignmentStatus{Missing: true},
+			[]string{"⚠️ 'Essay' in English is now marked MISSING"}},
+		{"newly late", &AssignmentStatus{Missing: true}, &AssignmentStatus{Missing: true, Late: true},
+			[]string{"⚠️ 'Essay' in English is now marked LATE"}},
+		{"still missing", &AssignmentStatus{Missing: true}, &AssignmentStatus{Missing: true}, nil},
+		{"no longer missing", &AssignmentStatus{Missing: true}, &AssignmentStatus{}, nil},
+		{"backup from before flags were tracked", nil, &AssignmentStatus{Missing: true}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := compareAssignments([]Assignment{assignment(tt.old)}, []Assignment{assignment(tt.new)})
+			var got []string
+			for _, change := range changes {
+				got = append(got, change.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	changes := compareAssignments(nil, []Assignment{assignment(&AssignmentStatus{Late: true})})
+	if len(changes) != 1 || changes[0].Kind != MarkedLate {
+		t.Errorf("new assignment already marked late: got %+v, want one MarkedLate change", changes)
+	}
+}
+
+func TestNotifyDirection(t *testing.T) {
+	useTestConfig(t)
+	changes := []Change{
+		{Kind: GradeChanged, ClassName: "Math", Old: "B (85%)", New: "A (92%)", Delta: 7},
+		{Kind: AssignmentGradeChanged, ClassName: "Math", Name: "Quiz", Old: "90%", New: "70%", Delta: -20},
+		{Kind: GradeChanged, ClassName: "Art", Old: "P", New: "NG"},
+		{Kind: NewAssignment, ClassName: "Art", Name: "Sketch", New: "60%"},
+	}
+
+	tests := []struct {
+		direction string
+		want      []string
+	}{
+		{"both", []string{"Math", "Quiz", "Art", "Sketch"}},
+		{"up", []string{"Math", "Art", "Sketch"}},
+		{"down", []string{"Quiz", "Art", "Sketch"}},
+	}
+	for _, tt := range tests {
+		config.NotifyDirection = tt.direction
+		var got []string
+		for _, change := range filterDirection(changes) {
+			name := change.Name
+			if name == "" {
+				name = change.ClassName
+			}
+			got = append(got, name)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("notify_direction %s: got %q, want %q", tt.direction, got, tt.want)
+		}
+	}
+}
+
+func TestTermIDsOverrideTitlePattern(t *testing.T) {
+	useTestConfig(t)
+	config.TermIDs = []int64{101, 999}
+
+	state, _, ok := currentStudentData(testStudent("B", "80"))
+	if !ok {
+		t.Fatal("no terms selected")
+	}
+	if want := []Term{{ID: 101, Title: "S1"}}; !slices.Equal(state.Terms, want) {
+		t.Errorf("got terms %+v, want %+v", state.Terms, want)
+	}
+	if len(state.Classes) != 1 || state.Classes[0].Grade != "ignored" {
+		t.Errorf("got classes %+v, want the S1 grade", state.Classes)
+	}
+
+	config.TermIDs = []int64{999}
+	if _, _, ok := currentStudentData(testStudent("B", "80")); ok {
+		t.Error("compared with none of term_ids in the fetched data")
+	}
+}
+
+func TestCurrentStudentDataReadsPointsAndPercent(t *testing.T) {
+	useTestConfig(t)
+	tests := []struct {
+		name, score, percent string
+		possible             float64
+		want                 string
+	}{
+		{"points and percent", "18", "90", 20, "18/20 (90%)"},
+		{"points only", "18", "", 20, "18/20 (90%)"},
+		{"percent only", "90", "", 0, "90%"},
+	}
+	for _, tt := range tests {
+		student := testStudent("B", tt.score)
+		student.Assignments[0].Pointspossible = tt.possible
+		student.AssignmentScores[0].Percent = tt.percent
+
+		state, _, ok := currentStudentData(student)
+		if !ok || len(state.Assignments) != 1 {
+			t.Fatalf("%s: got assignments %+v", tt.name, state.Assignments)
+		}
+		if got := assignmentScore(state.Assignments[0]); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRemindersSentOnce(t *testing.T) {
+	useTestConfig(t)
+	config.ReminderDays = 2
+	store := jsonBackupStore{RemindersFile: filepath.Join(t.TempDir(), "reminders.json")}
+	today := calendarDate(localNow())
+	assignments := []Assignment{
+		{ID: 1, Name: "Essay", ClassName: "English", DueDate: today.AddDate(0, 0, 1)},
+		{ID: 2, Name: "Quiz", ClassName: "Biology", DueDate: today.AddDate(0, 0, 1), Grade: "90%"},
+		{ID: 3, Name: "Project", ClassName: "Art", DueDate: today.AddDate(0, 0, 5)},
+		{ID: 4, Name: "Worksheet", ClassName: "Math", DueDate: today.AddDate(0, 0, -1)},
+	}
+
+	notifier := &capturingNotifier{}
+	sendReminders(context.Background(), notifier, store, assignments)
+	want := "English\n  Reminder: 'Essay' in class English is due " + today.AddDate(0, 0, 1).Format("Mon Jan 2")
+	if got := strings.Join(notifier.messages, "\n"); got != want {
+		t.Errorf("first poll: got %q, want %q", got, want)
+	}
+
+	notifier = &capturingNotifier{}
+	sendReminders(context.Background(), notifier, store, assignments)
+	if len(notifier.messages) != 0 {
+		t.Errorf("second poll: got %q, want no reminders", notifier.messages)
+	}
+}
+
+func TestBackupSchemaVersions(t *testing.T) {
+	dir := t.TempDir()
+	legacy := filepath.Join(dir, "legacy.json")
+	if err := os.WriteFile(legacy, []byte(`[{"ID": 1, "Name": "Quiz", "Grade": "90%"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	assignments, err := loadBackupDataAssignments(legacy)
+	if err != nil || len(assignments) != 1 || assignments[0].Name != "Quiz" || assignments[0].Comment != nil {
+		t.Fatalf("loading a version 1 backup: got %+v, %v", assignments, err)
+	}
+
+	current := filepath.Join(dir, "current.json")
+	if err := saveState(current, State{Assignments: assignments}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(current)
+	if !strings.Contains(string(data), `"schemaVersion": 3`) {
+		t.Errorf("saved backup has no schema version:\n%s", data)
+	}
+	if reloaded, err := loadBackupDataAssignments(current); err != nil || len(reloaded) != 1 {
+		t.Errorf("reloading: got %+v, %v", reloaded, err)
+	}
+
+	future := filepath.Join(dir, "future.json")
+	os.WriteFile(future, []byte(`{"schemaVersion": 99, "classes": []}`), 0o644)
+	if _, err := loadBackupDataClasses(future); err == nil {
+		t.Error("loading a backup from a newer version succeeded, want an error")
+	}
+}
+
+func TestStateMigratesSeparateBackups(t *testing.T) {
+	dir := t.TempDir()
+	store := jsonBackupStore{
+		StateFile:       filepath.Join(dir, "state.json"),
+		ClassesFile:     filepath.Join(dir, "classes.json"),
+		AssignmentsFile: filepath.Join(dir, "assignments.json"),
+	}
+	if _, err := store.LoadState(); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("loading with no backups: got %v, want fs.ErrNotExist", err)
+	}
+
+	os.WriteFile(store.ClassesFile, []byte(`[{"ID": 1, "Name": "Biology", "Grade": "A"}]`), 0o644)
+	os.WriteFile(store.AssignmentsFile, []byte(`{"schemaVersion": 2, "assignments": [{"ID": 2, "Name": "Quiz"}]}`), 0o644)
+	state, err := store.LoadState()
+	if err != nil || len(state.Classes) != 1 || len(state.Assignments) != 1 {
+		t.Fatalf("migrating: got %+v, %v", state, err)
+	}
+
+	if err := store.SaveState(state); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(store.ClassesFile); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("old classes backup still there after saving: %v", err)
+	}
+	if reloaded, err := store.LoadState(); err != nil || len(reloaded.Classes) != 1 || len(reloaded.Assignments) != 1 {
+		t.Errorf("reloading: got %+v, %v", reloaded, err)
+	}
+}
+
+func TestRotateStateFile(t *testing.T) {
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, "state_1.json")
+	now := time.Now()
+	for _, age := range []time.Duration{time.Hour, 2 * time.Hour, 3 * time.Hour, 48 * time.Hour} {
+		old := filepath.Join(dir, "state_1-"+now.Add(-age).Format(archiveTimeFormat)+".json")
+		os.WriteFile(old, []byte("{}"), 0o644)
+	}
+	other := filepath.Join(dir, "state_1-notes.json")
+	os.WriteFile(other, []byte("{}"), 0o644)
+	os.WriteFile(stateFile, []byte("{}"), 0o644)
+
+	if err := rotateStateFile(stateFile, 3, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	archives, _ := filepath.Glob(filepath.Join(dir, "state_1-2*.json"))
+	if len(archives) != 3 {
+		t.Errorf("got archives %q, want the newest 3", archives)
+	}
+	for _, path := range []string{stateFile, other} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s should be left alone: %v", path, err)
+		}
+	}
+}
+
+func TestGenericWebhookNotifier(t *testing.T) {
+	var got map[string]string
+	var gotMethod, gotHeader string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod, gotHeader = r.Method, r.Header.Get("X-Key")
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer server.Close()
+
+	notifier := &GenericWebhookNotifier{
+		URL:     server.URL,
+		Method:  http.MethodPut,
+		Headers: map[string]string{"X-Key": "secret"},
+		Body:    `{"title": "Grades", "text": {{json .Message}}}`,
+	}
+	message := "Grade changed for \"Biology\": B -> A\nGrade changed for Art: C -> B"
+	if err := notifier.Notify(context.Background(), textMessage(message)); err != nil {
+		t.Fatal(err)
+	}
+	if gotMethod != http.MethodPut || gotHeader != "secret" || got["title"] != "Grades" || got["text"] != message {
+		t.Errorf("got %s with X-Key %q and body %q", gotMethod, gotHeader, got)
+	}
+
+	notifier.Body = `{"text": "{{.Message}}"}`
+	if err := notifier.Notify(context.Background(), textMessage(message)); err == nil {
+		t.Error("sending a body that isn't valid JSON succeeded, want an error")
+	}
+}
+
+func TestChangeEmoji(t *testing.T) {
+	useTestConfig(t)
+	config.ChangeEmoji = true
+	config.ChangeMarkers = map[string]string{"removed": ""}
+
+	changes := compareGrades(
+		[]Class{{ID: 1, Name: "Art", Grade: "85"}, {ID: 2, Name: "Gym", Grade: "90"}, {ID: 3, Name: "Band", Grade: "A"}, {ID: 4, Name: "Latin", Grade: "B"}},
+		[]Class{{ID: 1, Name: "Art", Grade: "92"}, {ID: 2, Name: "Gym", Grade: "80"}, {ID: 3, Name: "Band", Grade: "P"}, {ID: 5, Name: "Chess", Grade: "A"}})
+
+	want := "⬆️ Grade changed for Art: 85 -> 92\n" +
+		"⬇️ Grade changed for Gym: 90 -> 80\n" +
+		"Grade changed for Band: A -> P\n" +
+		"🆕 New class added: Chess with grade A\n" +
+		"Class removed: Latin"
+	if got := strings.Join(groupChanges(changes).all(), "\n"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLetterAndPercentGradeDropIsColoredAsDecrease(t *testing.T) {
+	useTestConfig(t)
+	changes := compareGrades(
+		[]Class{{ID: 1, Name: "Biology", Grade: "A-", Percent: 91}},
+		[]Class{{ID: 1, Name: "Biology", Grade: "C", Percent: 72}})
+
+	message := formatChangeGroups(groupChanges(changes).groups[""])
+	if want := "Biology\n  Grade changed for Biology: A- (91%) -> C (72%)"; message.String() != want {
+		t.Fatalf("got message %q, want %q", message, want)
+	}
+	if !message.dropped() {
+		t.Error("message isn't marked as a grade drop")
+	}
+	if embeds := buildEmbeds(message.Lines); len(embeds) != 1 || embeds[0].Color != embedColorDecrease {
+		t.Errorf("got embeds %+v, want one with the decrease color", embeds)
+	}
+}
+
+func TestMessageLoadsQueuedStrings(t *testing.T) {
+	var messages []Message
+	data := `["Biology\n  Grade changed for Biology: 85 -> 80", {"Lines": [{"Text": "Art", "Direction": -1}]}]`
+	if err := json.Unmarshal([]byte(data), &messages); err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[0].String() != "Biology\n  Grade changed for Biology: 85 -> 80" || !messages[1].dropped() {
+		t.Errorf("got %+v, want the old plain string and the new message", messages)
+	}
+}
+
+func TestNotificationQueueKeepsPendingMessagesAcrossRestarts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+	file := filepath.Join(t.TempDir(), "pending.json")
+
+	failing := &NotificationQueue{Notifier: &SlackNotifier{WebhookURL: server.URL}, File: file}
+	failing.Notify(context.Background(), textMessage("Biology\n  Grade changed"))
+
+	captured := &capturingNotifier{}
+	restarted := &NotificationQueue{Notifier: captured, File: file}
+	restarted.Flush(context.Background())
+	if len(captured.messages) != 1 || captured.messages[0] != "Biology\n  Grade changed" {
+		t.Fatalf("got %q after a restart, want the undelivered message", captured.messages)
+	}
+	if pending := loadPendingNotifications(file); len(pending) != 0 {
+		t.Errorf("got %d messages still pending after delivery", len(pending))
+	}
+}
+
+func TestNotificationQueueDropsRejectedMessages(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	queue := &NotificationQueue{
+		Notifier: &GenericWebhookNotifier{URL: server.URL, Method: http.MethodPost, Body: defaultWebhookBody},
+		Cooldown: time.Hour,
+		File:     filepath.Join(t.TempDir(), "pending.json"),
+	}
+	queue.Notify(context.Background(), textMessage("rejected"))
+	queue.Notify(context.Background(), textMessage("next"))
+	if len(bodies) != 2 || !strings.Contains(bodies[1], "next") {
+		t.Errorf("got requests %q, want the next message sent right after the rejected one", bodies)
+	}
+	if len(queue.pending) != 0 || len(loadPendingNotifications(queue.File)) != 0 {
+		t.Errorf("rejected message is still queued: %+v", queue.pending)
+	}
+}
+
+func TestDiscordDoesNotResendDeliveredChunks(t *testing.T) {
+	var contents []string
+	failed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload WebhookMessage
+		json.NewDecoder(r.Body).Decode(&payload)
+		contents = append(contents, payload.Content[:5])
+		if len(contents) == 2 && !failed {
+			failed = true
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	notifier := &DiscordNotifier{WebhookURL: server.URL}
+	message := textMessage(strings.Repeat("a", discordContentMax) + "\n" + strings.Repeat("b", 10))
+	if err := notifier.Notify(context.Background(), message); err == nil {
+		t.Fatal("expected the second chunk to fail")
+	}
+	if err := notifier.Notify(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"aaaaa", "bbbbb", "bbbbb"}; !slices.Equal(contents, want) {
+		t.Errorf("got chunks %q, want %q", contents, want)
+	}
+}
+
+func TestStudentQuietSchedulesFallBackToGlobalSettings(t *testing.T) {
+	useTestConfig(t)
+	today := localNow().Format("2006-01-02")
+	noHours, weekdays := "", false
+	config.QuietHours = "22:00-07:00"
+	config.QuietWeekends = true
+	config.Holidays = []string{today}
+	config.StudentSettings = map[string]StudentSettings{
+		"Sam":  {QuietHours: &noHours, QuietWeekends: &weekdays, Holidays: &[]string{}},
+		"1234": {QuietHours: &noHours},
+	}
+	schedules := config.studentQuietSchedules()
+	if want := (QuietSchedule{}); !sameSchedule(schedules["Sam"], want) {
+		t.Errorf("got %+v for Sam, want every setting overridden", schedules["Sam"])
+	}
+	if want := (QuietSchedule{Weekends: true, Holidays: []string{today}}); !sameSchedule(schedules["1234"], want) {
+		t.Errorf("got %+v for 1234, want the global weekends and holidays", schedules["1234"])
+	}
+
+	dir := t.TempDir()
+	recorder := &capturingNotifier{}
+	quiet := &QuietHoursNotifier{
+		Notifier: recorder,
+		Schedule: config.quietSchedule(),
+		File:     filepath.Join(dir, "quiet.json"),
+		Students: schedules,
+	}
+	student := testStudent("A", "90")
+	key, ok := studentSettingsKey(student)
+	if !ok || key != "Sam" {
+		t.Fatalf("got student_settings entry %q for Sam", key)
+	}
+	quiet.Notify(withQuietStudent(context.Background(), key), textMessage("Sam's grade"))
+	quiet.Notify(context.Background(), textMessage("held for the holiday"))
+	quiet.Notify(withQuietStudent(context.Background(), "1234"), textMessage("held for 1234"))
+	if want := []string{"Sam's grade"}; !slices.Equal(recorder.messages, want) {
+		t.Errorf("sent %q, want %q", recorder.messages, want)
+	}
+	if held := loadQuietQueue(quiet.studentFile("1234")); len(held) != 1 {
+		t.Errorf("got %d messages held for 1234, want 1 in its own file", len(held))
+	}
+}
+
+func sameSchedule(a, b QuietSchedule) bool {
+	return a.Hours == b.Hours && a.Weekends == b.Weekends && slices.Equal(a.Holidays, b.Holidays)
+}
+
+func TestPushoverSendsGradeDropsAtHighPriority(t *testing.T) {
+	useTestConfig(t)
+	var priorities []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		priorities = append(priorities, r.FormValue("priority"))
+		fmt.Fprint(w, `{"status": 1}`)
+	}))
+	defer server.Close()
+	saved := pushoverAPIURL
+	pushoverAPIURL = server.URL
+	t.Cleanup(func() { pushoverAPIURL = saved })
+
+	notifier := &PushoverNotifier{Token: "token", User: "user"}
+	for _, newClass := range []Class{
+		{ID: 1, Name: "Biology", Grade: "C", Percent: 72},
+		{ID: 1, Name: "Biology", Grade: "A", Percent: 95},
+	} {
+		changes := compareGrades([]Class{{ID: 1, Name: "Biology", Grade: "A-", Percent: 91}}, []Class{newClass})
+		if err := groupChanges(changes).notify(context.Background(), notifier); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"1", "0"}; !slices.Equal(priorities, want) {
+		t.Errorf("got priorities %q for a drop then a rise, want %q", priorities, want)
+	}
+}
+
+func TestBuildEmbedsGroupsChangesByClass(t *testing.T) {
+	changes := &routedChanges{}
+	changes.add("", "Biology", MessageLine{Text: "Grade changed for Biology: 85 -> 80", Direction: -1})
+	changes.add("", "Art", MessageLine{Text: "New assignment added: 'Sketch' in class Art with grade 95%"})
+	changes.add("", "Biology", MessageLine{Text: "Assignment removed: 'Lab' from class Biology"})
+
+	message := formatChangeGroups(changes.groups[""])
+	embeds := buildEmbeds(append(textMessage("Schedule changed: 6 -> 7 classes").Lines, message.Lines...))
+	if len(embeds) != 1 {
+		t.Fatalf("got %d embeds, want 1", len(embeds))
+	}
+	want := []EmbedField{
+		{Name: "Schedule changed", Value: "6 -> 7 classes"},
+		{Name: "Biology", Value: "Grade changed for Biology: 85 -> 80\nAssignment removed: 'Lab' from class Biology"},
+		{Name: "Art", Value: "New assignment added: 'Sketch' in class Art with grade 95%"},
+	}
+	if fmt.Sprint(embeds[0].Fields) != fmt.Sprint(want) {
+		t.Errorf("got fields %q, want %q", embeds[0].Fields, want)
+	}
+	if embeds[0].Color != embedColorDecrease {
+		t.Errorf("got color %#x, want the decrease color", embeds[0].Color)
+	}
+}
+
+func TestGradeTrendsComeFromTheStudentsOwnHistory(t *testing.T) {
+	useTestConfig(t)
+	config.HistoryFile = filepath.Join(t.TempDir(), "history.jsonl")
+	config.HistoryTrendDays = 7
+
+	// Siblings in the same section share a class ID
+	for _, grades := range [][2]float64{{80, 60}, {80, 60}, {85, 65}, {90, 70}} {
+		if err := appendGradeHistory(config.HistoryFile, 1, []Class{{ID: 10, Name: "Biology", Grade: "B", Percent: grades[0]}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := appendGradeHistory(config.HistoryFile, 2, []Class{{ID: 10, Name: "Biology", Grade: "D", Percent: grades[1]}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changes := []Change{{Kind: GradeChanged, ClassID: 10, ClassName: "Biology", Old: "B (85%)", New: "B (90%)"}}
+	addGradeTrends(1, changes)
+	if want := ", up 10 points this week " + sparkline([]float64{80, 85, 90}); changes[0].Detail != want {
+		t.Errorf("got detail %q, want %q", changes[0].Detail, want)
+	}
+}
+
+func TestPruneGradeHistory(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history.jsonl")
+	var data []byte
+	for _, age := range []time.Duration{100 * 24 * time.Hour, time.Hour} {
+		line, _ := json.Marshal(GradeRecord{Time: time.Now().Add(-age), StudentID: 1, ClassID: 10, Grade: "90"})
+		data = append(append(data, line...), '\n')
+	}
+	if err := os.WriteFile(filename, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pruneGradeHistory(filename, time.Now().Add(-90*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	history, err := loadGradeHistory(filename, 1)
+	if err != nil || len(history[10]) != 1 {
+		t.Errorf("got %+v, %v, want only the recent record", history, err)
+	}
+}
+
+func TestCompareReturnsStructuredChanges(t *testing.T) {
+	useTestConfig(t)
+
+	changes := compareGrades([]Class{{ID: 1, Name: "Art", Grade: "85"}}, []Class{{ID: 1, Name: "Art", Grade: "92"}})
+	want := Change{Kind: GradeChanged, ClassID: 1, ClassName: "Art", Old: "85", New: "92", Delta: 7}
+	if len(changes) != 1 || changes[0] != want {
+		t.Errorf("got %+v, want [%+v]", changes, want)
+	}
+
+	changes = compareAssignments(nil, []Assignment{{ID: 2, Name: "Quiz", Grade: "90%", ClassID: 1, ClassName: "Art"}})
+	want = Change{Kind: NewAssignment, ClassID: 1, ClassName: "Art", Name: "Quiz", New: "90%"}
+	if len(changes) != 1 || changes[0] != want {
+		t.Errorf("got %+v, want [%+v]", changes, want)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	useTestConfig(t)
+	student := testStudent("B", "80")
+	filename := filepath.Join(t.TempDir(), "grades.csv")
+
+	if err := exportCSV(context.Background(), &fakeFetcher{students: []*powerschool.StudentDataVO{student}}, filename); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "student,class,class grade,assignment,score,due date\n" +
+		"Sam,Biology,B,,,\n" +
+		"Sam,Biology,,Cells Quiz,80%," + student.Assignments[0].DueDate.Format(time.DateOnly) + "\n"
+	if string(data) != want {
+		t.Errorf("got CSV\n%s\nwant\n%s", data, want)
+	}
+	if _, err := os.Stat(config.StateFile); !os.IsNotExist(err) {
+		t.Errorf("export should not write the state file, stat err = %v", err)
+	}
+}
+
+func TestExportHTML(t *testing.T) {
+	useTestConfig(t)
+	student := testStudent("<B>", "65")
+	filename := filepath.Join(t.TempDir(), "report.html")
+
+	if err := exportHTML(context.Background(), &fakeFetcher{students: []*powerschool.StudentDataVO{student}}, filename); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<h1>Sam</h1>",
+		`<tr class="class"><td>Biology</td><td class="none">&lt;B&gt;</td>`,
+		`<td class="assignment">Cells Quiz</td><td class="f">65%</td>`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("report missing %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestJitteredInterval(t *testing.T) {
+	if got := jitteredInterval(time.Minute, 0); got != time.Minute {
+		t.Errorf("no jitter: got %s, want 1m", got)
+	}
+	for i := 0; i < 100; i++ {
+		if got := jitteredInterval(time.Minute, 10); got < 54*time.Second || got > 66*time.Second {
+			t.Fatalf("10%% jitter on 1m: got %s, want within 54s-66s", got)
+		}
+	}
+}
+
+func TestSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "ps_password")
+	if err := os.WriteFile(passwordFile, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PS_PASSWORD", "inline")
+	t.Setenv("PS_PASSWORD_FILE", passwordFile)
+
+	cfg := defaultConfig()
+	if err := cfg.readSecretFiles(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PowerSchoolPassword != "from-file" {
+		t.Errorf("got password %q, want the trimmed file contents", cfg.PowerSchoolPassword)
+	}
+
+	t.Setenv("PS_PASSWORD_FILE", filepath.Join(dir, "missing"))
+	if err := cfg.readSecretFiles(); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got error %v for a missing secret file, want fs.ErrNotExist", err)
+	}
+}
+
+func TestValidateSetup(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig := func(stateFile string) string {
+		path := filepath.Join(dir, "config.json")
+		data, err := json.Marshal(map[string]string{
+			"powerschool_url":      "https://ps.example.com",
+			"powerschool_username": "student",
+			"powerschool_password": "secret",
+			"discord_webhook_url":  "https://discord.com/api/webhooks/1/abc",
+			"state_file":           stateFile,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	var out strings.Builder
+	if !validateSetup(&out, writeConfig(filepath.Join(dir, "state.json")), "") {
+		t.Errorf("valid config failed validation:\n%s", out.String())
+	}
+
+	out.Reset()
+	if validateSetup(&out, writeConfig(filepath.Join(dir, "missing", "state.json")), "soon") {
+		t.Errorf("validation passed with a bad interval and an unwritable state file:\n%s", out.String())
+	}
+	for _, want := range []string{"FAIL  poll interval soon", "FAIL  state_file"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report is missing %q:\n%s", want, out.String())
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "state.json")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("validation created the state file")
+	}
+}
+
+func TestDedupeNotifierSkipsRecentDuplicates(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sent.json")
+	recorder := &capturingNotifier{}
+	dedupe := &DedupeNotifier{Notifier: recorder, Window: time.Hour, File: file}
+
+	for _, message := range []string{"Math: 90 -> 85", "Math: 85 -> 90", "Math: 90 -> 85"} {
+		if err := dedupe.Notify(context.Background(), textMessage(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"Math: 90 -> 85", "Math: 85 -> 90"}; !slices.Equal(recorder.messages, want) {
+		t.Errorf("got messages %q, want %q", recorder.messages, want)
+	}
+
+	// A restart remembers what was sent
+	restarted := &DedupeNotifier{Notifier: recorder, Window: time.Hour, File: file}
+	if err := restarted.Notify(context.Background(), textMessage("Math: 85 -> 90")); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.messages) != 2 {
+		t.Errorf("duplicate was sent again after a restart: %q", recorder.messages)
+	}
+
+	// Outside the window it's sent again
+	expired := &DedupeNotifier{Notifier: recorder, Window: time.Nanosecond, File: file}
+	if err := expired.Notify(context.Background(), textMessage("Math: 85 -> 90")); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.messages) != 3 {
+		t.Errorf("message outside the window wasn't sent: %q", recorder.messages)
+	}
+}
+
+func TestDedupeNotifierDropsRepeatedChangesFromGroupedMessages(t *testing.T) {
+	recorder := &capturingNotifier{}
+	dedupe := &DedupeNotifier{Notifier: recorder, Window: time.Hour, File: filepath.Join(t.TempDir(), "sent.json")}
+
+	first := formatChangeGroups([]changeGroup{{Class: "Math", Lines: []MessageLine{{Text: "Grade changed: 90 -> 85"}}}})
+	second := formatChangeGroups([]changeGroup{
+		{Class: "Math", Lines: []MessageLine{{Text: "Grade changed: 90 -> 85"}}},
+		{Class: "Art", Lines: []MessageLine{{Text: "Grade changed: 90 -> 85"}, {Text: "New assignment: Sketch"}}},
+	})
+	for _, message := range []Message{first, second, second} {
+		if err := dedupe.Notify(context.Background(), message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{
+		"Math\n  Grade changed: 90 -> 85",
+		"Art\n  Grade changed: 90 -> 85\n  New assignment: Sketch",
+	}
+	if !slices.Equal(recorder.messages, want) {
+		t.Errorf("got messages %q, want %q", recorder.messages, want)
+	}
+}
+
+// validTestConfig returns a config that passes validate, to change one
+// setting at a time.
+func validTestConfig() Config {
+	cfg := defaultConfig()
+	cfg.PowerSchoolURL = "https://ps.example.com"
+	cfg.PowerSchoolUsername = "student"
+	cfg.PowerSchoolPassword = "secret"
+	cfg.DiscordWebhookURL = "https://discord.com/api/webhooks/1/abc"
+	return cfg
+}
+
+func TestDedupeWindowMustCoverThePollInterval(t *testing.T) {
+	cfg := validTestConfig()
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("default config failed validation: %v", err)
+	}
+	cfg.PollInterval = "2h"
+	if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "dedupe_window") {
+		t.Errorf("got %v for a dedupe window shorter than the poll interval", err)
+	}
+	cfg.DedupeWindow = 0
+	if err := cfg.validate(); err != nil {
+		t.Errorf("got %v with dedupe turned off", err)
+	}
+}
+
+// flushCountingNotifier counts how often it's flushed.
+type flushCountingNotifier struct {
+	capturingNotifier
+	flushes int
+}
+
+func (f *flushCountingNotifier) Flush(ctx context.Context) {
+	f.flushes++
+}
+
+func TestMaintenanceWindowSkipsOnlyTheFetch(t *testing.T) {
+	useTestConfig(t)
+	now := localNow()
+	config.MaintenanceWindows = []MaintenanceWindow{{
+		Start: now.Add(-time.Hour).Format("15:04"),
+		End:   now.Add(time.Hour).Format("15:04"),
+	}}
+	t.Cleanup(func() { inMaintenanceWindow = false })
+	lastRun := lastSuccessfulRun.Load()
+
+	fetcher := &fakeFetcher{students: []*powerschool.StudentDataVO{testStudent("B", "80")}}
+	notifier := &flushCountingNotifier{}
+	if err := fetchAndCompare(context.Background(), fetcher, notifier); err != nil {
+		t.Fatal(err)
+	}
+	if fetcher.calls != 0 {
+		t.Errorf("fetched %d times during maintenance", fetcher.calls)
+	}
+	if notifier.flushes != 1 {
+		t.Errorf("flushed %d times, want held notifications delivered anyway", notifier.flushes)
+	}
+	if lastSuccessfulRun.Load() != lastRun {
+		t.Error("a poll skipped for maintenance was marked successful")
+	}
+}
+
+func TestMaintenanceWindowsAreValidated(t *testing.T) {
+	tests := []struct {
+		window MaintenanceWindow
+		valid  bool
+	}{
+		{MaintenanceWindow{Days: []string{"Sunday", "sat"}, Start: "23:00", End: "02:00"}, true},
+		{MaintenanceWindow{Start: "01:00", End: "03:00"}, true},
+		{MaintenanceWindow{Days: []string{"Sundy"}, Start: "01:00", End: "03:00"}, false},
+		{MaintenanceWindow{Days: []string{"tues"}, Start: "01:00", End: "03:00"}, false},
+		{MaintenanceWindow{Start: "1am", End: "03:00"}, false},
+		{MaintenanceWindow{Start: "01:00", End: "25:00"}, false},
+		{MaintenanceWindow{Start: "01:00", End: "01:00"}, false},
+	}
+	for _, tt := range tests {
+		cfg := validTestConfig()
+		cfg.MaintenanceWindows = []MaintenanceWindow{tt.window}
+		err := cfg.validate()
+		if (err == nil) != tt.valid {
+			t.Errorf("%+v: got error %v, want valid=%v", tt.window, err, tt.valid)
+		}
+		if err != nil && !strings.Contains(err.Error(), "maintenance_windows entry 1") {
+			t.Errorf("%+v: error %v doesn't name the window", tt.window, err)
+		}
+	}
+}
+
+func TestRecapTimeIsValidated(t *testing.T) {
+	cfg := validTestConfig()
+	cfg.RecapTime = "8pm"
+	if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "recap_time") {
+		t.Errorf("got %v for recap_time 8pm", err)
+	}
+	cfg.RecapTime = "20:00"
+	if err := cfg.validate(); err != nil {
+		t.Errorf("got %v for recap_time 20:00", err)
+	}
+}
+
+func TestLogsMaskCredentialsAndWebhookTokens(t *testing.T) {
+	useTestConfig(t)
+	config.PowerSchoolPassword = "hunter2-password"
+	config.SMTPPassword = "smtp-app-password"
+	saved := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(saved) })
+
+	secrets := []string{"hunter2-password", "smtp-app-password", "discordToken123", "slackToken456", "123456:telegramToken"}
+	for _, format := range []string{"text", "json"} {
+		var out strings.Builder
+		if format == "text" {
+			slog.SetDefault(slog.New(&colorHandler{out: &out, level: slog.LevelDebug, mu: &sync.Mutex{}}))
+		} else {
+			slog.SetDefault(slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+		}
+
+		logError("Login failed for student with password hunter2-password")
+		logWarning("Could not authenticate to SMTP with smtp-app-password")
+		logDebug(`Post "https://discord.com/api/webhooks/1234/discordToken123": timeout`)
+		logInfo(`Post "https://hooks.slack.com/services/T000/B000/slackToken456": EOF`)
+		logError(`Post "https://api.telegram.org/bot123456:telegramToken/sendMessage": EOF`)
+
+		for _, secret := range secrets {
+			if strings.Contains(out.String(), secret) {
+				t.Errorf("%s logs leak %q:\n%s", format, secret, out.String())
+			}
+		}
+		if got := strings.Count(out.String(), "***"); got != len(secrets) {
+			t.Errorf("%s logs masked %d secrets, want %d:\n%s", format, got, len(secrets), out.String())
+		}
+	}
+}
+
+func TestPIDFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "notifier.pid")
+	if err := os.WriteFile(filename, []byte("999999\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writePIDFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), fmt.Sprintf("%d\n", os.Getpid()); got != want {
+		t.Errorf("PID file has %q, want %q", got, want)
+	}
+
+	removePIDFile(filename)
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("PID file should be removed on shutdown, stat err = %v", err)
+	}
+}
+
+func TestProxyTunnelsHTTPS(t *testing.T) {
+	target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer target.Close()
+
+	var connects []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "expected CONNECT", http.StatusMethodNotAllowed)
+			return
+		}
+		connects = append(connects, r.Host)
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+		w.WriteHeader(http.StatusOK)
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		go io.Copy(upstream, buf)
+		io.Copy(conn, upstream)
+	}))
+	defer proxy.Close()
+
+	cfg := defaultConfig()
+	cfg.ProxyURL = proxy.URL
+	transport := target.Client().Transport.(*http.Transport).Clone()
+	transport.Proxy = cfg.proxyFunc()
+	resp, err := (&http.Client{Transport: transport}).Get(target.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "ok" {
+		t.Errorf("got body %q through the proxy, want \"ok\"", body)
+	}
+	if want := strings.TrimPrefix(target.URL, "https://"); len(connects) != 1 || connects[0] != want {
+		t.Errorf("proxy saw CONNECT to %q, want one to %q", connects, want)
+	}
+}
+
+func TestGradeScale(t *testing.T) {
+	useTestConfig(t)
+
+	tests := []struct {
+		class  Class
+		points float64
+		ok     bool
+	}{
+		{Class{Grade: "B+"}, 3.3, true},
+		{Class{Grade: "b+"}, 3.3, true},
+		{Class{Grade: "84"}, 3.0, true},
+		{Class{Grade: "", Percent: 91.5}, 3.7, true},
+		{Class{Grade: "103%"}, 4.0, true},
+		{Class{Name: "Gym", Grade: "P"}, 0, false},
+	}
+	for _, tt := range tests {
+		points, ok := gradePoints(tt.class)
+		if points != tt.points || ok != tt.ok {
+			t.Errorf("gradePoints(%+v) = %v, %v; want %v, %v", tt.class, points, ok, tt.points, tt.ok)
+		}
+	}
+
+	config.GradeScale = []GradeBand{{Letter: "E", Min: 90, Points: 4}, {Letter: "S", Min: 70, Max: 90, Points: 3}}
+	if value, ok := gradePercent(Class{Grade: "S"}); !ok || value != 70 {
+		t.Errorf("gradePercent(S) = %v, %v; want the bottom of its band, 70", value, ok)
+	}
+	if _, ok := gradePoints(Class{Grade: "B"}); ok {
+		t.Error("a letter missing from a custom scale should not count toward GPA")
+	}
+}
+
+func TestNewTermStartsNewBaseline(t *testing.T) {
+	useTestConfig(t)
+	store := jsonBackupStore{StateFile: config.StateFile}
+	notifier := &capturingNotifier{}
+
+	compareStudent(context.Background(), testStudent("B", "80"), notifier, store, config.RecapChangesFile)
+
+	// Q1 has ended and Q2 starts with the grades reset
+	next := testStudent("A", "")
+	next.ReportingTerms[0].Id, next.ReportingTerms[0].Title = 102, "Q2"
+	next.FinalGrades[0].ReportingTermId = 102
+	changes := compareStudent(context.Background(), next, notifier, store, config.RecapChangesFile)
+
+	if len(changes) != 0 {
+		t.Errorf("got changes %q, want a new baseline", groupChanges(changes).all())
+	}
+	if got, want := strings.Join(notifier.messages, "\n"), "New grading period started: Q2"; got != want {
+		t.Errorf("got notifications %q, want %q", got, want)
+	}
+	state, err := loadState(config.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Terms) != 1 || state.Terms[0] != (Term{ID: 102, Title: "Q2"}) {
+		t.Errorf("saved terms %+v, want Q2", state.Terms)
+	}
+}
+
+func TestLastRunIsSavedAndLoaded(t *testing.T) {
+	useTestConfig(t)
+	store := jsonBackupStore{StateFile: studentFilePath(config.StateFile, 1)}
+
+	if _, ok := loadLastRun(); ok {
+		t.Fatal("found a last run before any poll")
+	}
+	before := time.Now()
+	compareStudent(context.Background(), testStudent("B", "80"), &capturingNotifier{}, store, config.RecapChangesFile)
+
+	last, ok := loadLastRun()
+	if !ok || last.Before(before) {
+		t.Errorf("loadLastRun() = %s, %v; want a time after %s", last, ok, before)
+	}
+}
+
+func TestFetchAndCompareMultipleStudentsInParallel(t *testing.T) {
+	useTestConfig(t)
+	students := func(grade string) []*powerschool.StudentDataVO {
+		var all []*powerschool.StudentDataVO
+		for i, name := range []string{"Sam", "Alex", "Jo"} {
+			student := testStudent(grade, "80")
+			student.StudentId = int64(i + 1)
+			student.Student.FirstName = name
+			all = append(all, student)
+		}
+		return all
+	}
+	fetcher := &fakeFetcher{students: students("B")}
+	notifier := &capturingNotifier{}
+
+	fetchAndCompare(context.Background(), fetcher, notifier)
+	fetcher.students = students("C")
+	fetchAndCompare(context.Background(), fetcher, notifier)
+
+	if len(notifier.messages) != 3 {
+		t.Fatalf("got %d messages, want one per student: %q", len(notifier.messages), notifier.messages)
+	}
+	all := strings.Join(notifier.messages, "\n")
+	for _, name := range []string{"Sam", "Alex", "Jo"} {
+		if !strings.Contains(all, "["+name+"] ") {
+			t.Errorf("no changes sent for %s: %q", name, all)
+		}
+	}
+	for id := int64(1); id <= 3; id++ {
+		if _, err := loadState(studentFilePath(config.StateFile, id)); err != nil {
+			t.Errorf("student %d: %v", id, err)
+		}
+	}
+}
+
+func TestAttendanceChanges(t *testing.T) {
+	useTestConfig(t)
+	config.NotifyAttendance = true
+	store := jsonBackupStore{StateFile: config.StateFile}
+	notifier := &capturingNotifier{}
+
+	student := func(attendance ...*powerschool.AttendanceVO) *powerschool.StudentDataVO {
+		s := testStudent("B", "80")
+		s.Sections[0].Enrollments = []*powerschool.SectionEnrollmentVO{{Id: 500}}
+		s.AttendanceCodes = []*powerschool.AttendanceCodeVO{
+			{Id: 1, AttCode: "", Description: "Present"},
+			{Id: 2, AttCode: "A", Description: "Unexcused Absence"},
+			{Id: 3, AttCode: "T", Description: "Tardy"},
+		}
+		s.Attendance = attendance
+		return s
+	}
+	before := student(&powerschool.AttendanceVO{Id: 1, Ccid: 500, AttCodeid: 2, AttDate: "2024-01-10T00:00:00Z"})
+	after := student(
+		&powerschool.AttendanceVO{Id: 1, Ccid: 500, AttCodeid: 2, AttDate: "2024-01-10T00:00:00Z"},
+		&powerschool.AttendanceVO{Id: 2, Ccid: 500, AttCodeid: 2, AttDate: "2024-01-15T00:00:00Z"},
+		&powerschool.AttendanceVO{Id: 3, Ccid: 500, AttCodeid: 3, AttDate: "2024-01-16"},
+		&powerschool.AttendanceVO{Id: 4, Ccid: 500, AttCodeid: 1, AttDate: "2024-01-17"},
+	)
+	after.Assignments[0].DueDate = before.Assignments[0].DueDate
+
+	compareStudent(context.Background(), before, notifier, store, config.RecapChangesFile)
+	changes := compareStudent(context.Background(), after, notifier, store, config.RecapChangesFile)
+
+	want := "New absence recorded in Biology on 2024-01-15 (Unexcused Absence)\n" +
+		"New tardy recorded in Biology on 2024-01-16 (Tardy)"
+	if got := strings.Join(groupChanges(changes).all(), "\n"); got != want {
+		t.Errorf("got changes %q, want %q", got, want)
+	}
+}
+
+func TestAttendanceStartsBaselineWhenTurnedOn(t *testing.T) {
+	useTestConfig(t)
+	store := jsonBackupStore{StateFile: config.StateFile}
+	student := testStudent("B", "80")
+	student.Sections[0].Enrollments = []*powerschool.SectionEnrollmentVO{{Id: 500}}
+	student.AttendanceCodes = []*powerschool.AttendanceCodeVO{{Id: 2, AttCode: "A", Description: "Absent"}}
+	student.Attendance = []*powerschool.AttendanceVO{{Id: 1, Ccid: 500, AttCodeid: 2, AttDate: "2024-01-10"}}
+
+	compareStudent(context.Background(), student, &capturingNotifier{}, store, config.RecapChangesFile)
+	config.NotifyAttendance = true
+	if changes := compareStudent(context.Background(), student, &capturingNotifier{}, store, config.RecapChangesFile); len(changes) != 0 {
+		t.Errorf("got changes %q, want a new attendance baseline", groupChanges(changes).all())
+	}
+}
+
+func TestNotifierSendsStopWhenContextIsCancelled(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	notifiers := []Notifier{
+		&DiscordNotifier{WebhookURL: server.URL},
+		&SlackNotifier{WebhookURL: server.URL},
+		&GenericWebhookNotifier{URL: server.URL, Method: http.MethodPost, Body: defaultWebhookBody},
+	}
+	for _, notifier := range notifiers {
+		start := time.Now()
+		err := notifier.Notify(ctx, textMessage("Biology\n  Grade changed"))
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%T: got error %v, want context.Canceled", notifier, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%T: took %s to give up", notifier, elapsed)
+		}
+	}
+	if requests != 0 {
+		t.Errorf("server got %d requests after the context was cancelled", requests)
+	}
+}
+
+func TestMatrixNotifier(t *testing.T) {
+	var paths []string
+	var sent matrixMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer good-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"errcode": "M_UNKNOWN_TOKEN", "error": "Invalid access token"}`)
+			return
+		}
+		paths = append(paths, r.Method+" "+r.URL.EscapedPath())
+		json.NewDecoder(r.Body).Decode(&sent)
+		fmt.Fprint(w, `{"event_id": "$1"}`)
+	}))
+	defer server.Close()
+
+	notifier := &MatrixNotifier{HomeserverURL: server.URL, AccessToken: "good-token", RoomID: "!room:example.org", HTML: true}
+	if err := notifier.Notify(context.Background(), textMessage("Biology\n  Grade changed for <Lab>")); err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 || !strings.HasPrefix(paths[0], "PUT /_matrix/client/v3/rooms/%21room:example.org/send/m.room.message/ps-diff-") {
+		t.Errorf("got requests %q", paths)
+	}
+	want := matrixMessage{
+		MsgType:       "m.text",
+		Body:          "Biology\n  Grade changed for <Lab>",
+		Format:        "org.matrix.custom.html",
+		FormattedBody: "<b>Biology</b><br><ul><li>Grade changed for &lt;Lab&gt;</li></ul>",
+	}
+	if sent != want {
+		t.Errorf("sent %+v, want %+v", sent, want)
+	}
+
+	notifier.AccessToken = "expired"
+	if err := notifier.Notify(context.Background(), textMessage("hi")); err == nil || !strings.Contains(err.Error(), "check matrix_access_token") {
+		t.Errorf("got error %v for a rejected token", err)
+	}
+}
+
+func TestMetricsReportFetchLatency(t *testing.T) {
+	useTestConfig(t)
+	saved := recentFetchLatencies
+	t.Cleanup(func() { recentFetchLatencies = saved })
+
+	recordFetchLatency(context.Background(), &capturingNotifier{}, 1500*time.Millisecond)
+	recorder := httptest.NewRecorder()
+	metricsHandler(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(recorder.Body.String(), "\nps_notifier_fetch_latency_seconds 1.5\n") {
+		t.Errorf("metrics missing the fetch latency:\n%s", recorder.Body.String())
+	}
+}
+
+func TestOTLPExporterPushesMetrics(t *testing.T) {
+	useTestConfig(t)
+	recordLowestGrade(42, []Class{{Grade: "B", Percent: 84}})
+	t.Cleanup(func() { recordLowestGrade(42, nil) })
+
+	var got otlpRequest
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer server.Close()
+
+	exporter := &otlpExporter{Endpoint: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}, start: time.Now()}
+	if err := exporter.export(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer token" {
+		t.Errorf("got Authorization %q", auth)
+	}
+	metrics := make(map[string]otlpMetric)
+	for _, m := range got.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m
+	}
+	if polls := metrics["ps_notifier_polls_total"]; polls.Sum == nil || !polls.Sum.IsMonotonic || polls.Sum.AggregationTemporality != otlpCumulative {
+		t.Errorf("polls total should be a cumulative sum, got %+v", polls)
+	}
+	lowest := metrics["ps_notifier_lowest_class_grade"]
+	if lowest.Gauge == nil || len(lowest.Gauge.DataPoints) != 1 || lowest.Gauge.DataPoints[0].AsDouble != 84 ||
+		lowest.Gauge.DataPoints[0].Attributes[0] != (otlpAttribute{Key: "student_id", Value: otlpAttrValue{StringValue: "42"}}) {
+		t.Errorf("got lowest class grade %+v", lowest)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	errTransient := errors.New("transient")
+	errFatal := errors.New("fatal")
+
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{"succeeds first time", []error{nil}, 1, nil},
+		{"succeeds after failures", []error{errTransient, errTransient, nil}, 3, nil},
+		{"gives up after all attempts", []error{errTransient, errTransient, errTransient, errTransient}, 3, errTransient},
+		{"stops on a permanent error", []error{errTransient, stopRetrying(errFatal), nil}, 2, errFatal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := retry(context.Background(), "test", 3, time.Millisecond, func() error {
+				calls++
+				return tt.errs[calls-1]
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("called %d times, want %d", calls, tt.wantCalls)
+			}
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil) != (err == nil) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+			var permanent *permanentError
+			if errors.As(err, &permanent) {
+				t.Errorf("permanent errors should be returned unwrapped, got %#v", err)
+			}
+		})
+	}
+}
+
+func TestRetryStopsWhenContextIsCancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	calls := 0
+	err := retry(ctx, "test", 5, time.Hour, func() error {
+		calls++
+		return errors.New("transient")
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || calls != 1 {
+		t.Errorf("got error %v after %d calls, want context.DeadlineExceeded after 1", err, calls)
+	}
+}
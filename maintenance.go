@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaintenanceWindow is a recurring period (e.g. a nightly sync) during which
+// PowerSchool isn't fetched. Start and End are "HH:MM"; a window whose End is
+// before its Start runs past midnight. Days are the weekday names (e.g.
+// "sunday" or "sun") the window starts on, or every day if empty.
+type MaintenanceWindow struct {
+	Days  []string `json:"days" yaml:"days"`
+	Start string   `json:"start" yaml:"start"`
+	End   string   `json:"end" yaml:"end"`
+}
+
+var inMaintenanceWindow bool
+
+// activeMaintenanceWindow returns the configured window containing t, if any.
+func activeMaintenanceWindow(t time.Time) (MaintenanceWindow, bool) {
+	minute := t.Hour()*60 + t.Minute()
+	for _, window := range config.MaintenanceWindows {
+		start, err1 := time.Parse("15:04", window.Start)
+		end, err2 := time.Parse("15:04", window.End)
+		if err1 != nil || err2 != nil {
+			logError(fmt.Sprintf("Invalid maintenance window %s-%s, expected HH:MM.", window.Start, window.End))
+			continue
+		}
+		startMinute := start.Hour()*60 + start.Minute()
+		endMinute := end.Hour()*60 + end.Minute()
+
+		if startMinute < endMinute {
+			if minute >= startMinute && minute < endMinute && windowStartsOn(window, t.Weekday()) {
+				return window, true
+			}
+			continue
+		}
+		// Wraps past midnight: the late part belongs to today, the early part to yesterday
+		if minute >= startMinute && windowStartsOn(window, t.Weekday()) {
+			return window, true
+		}
+		if minute < endMinute && windowStartsOn(window, t.AddDate(0, 0, -1).Weekday()) {
+			return window, true
+		}
+	}
+	return MaintenanceWindow{}, false
+}
+
+// parseWeekday reads a weekday name, in full or as its first three letters,
+// in any case.
+func parseWeekday(name string) (time.Weekday, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		full := strings.ToLower(day.String())
+		if name == full || name == full[:3] {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+// validate reports what's wrong with the window's days and times, if anything.
+func (w MaintenanceWindow) validate() []string {
+	var problems []string
+	for _, name := range w.Days {
+		if _, ok := parseWeekday(name); !ok {
+			problems = append(problems, fmt.Sprintf("%q isn't a day of the week", name))
+		}
+	}
+	start, err1 := time.Parse("15:04", w.Start)
+	end, err2 := time.Parse("15:04", w.End)
+	if err1 != nil || err2 != nil {
+		problems = append(problems, fmt.Sprintf("%s-%s must be HH:MM times like 01:00-03:00", w.Start, w.End))
+	} else if start.Equal(end) {
+		problems = append(problems, fmt.Sprintf("%s-%s starts and ends at the same time", w.Start, w.End))
+	}
+	return problems
+}
+
+func windowStartsOn(window MaintenanceWindow, day time.Weekday) bool {
+	if len(window.Days) == 0 {
+		return true
+	}
+	for _, name := range window.Days {
+		if d, ok := parseWeekday(name); ok && d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// checkMaintenanceWindow reports whether fetching should be skipped right now,
+// logging when a maintenance window is entered and exited.
+func checkMaintenanceWindow() bool {
+	window, active := activeMaintenanceWindow(localNow())
+	if active && !inMaintenanceWindow {
+		logInfo(fmt.Sprintf("Entering PowerSchool maintenance window (%s-%s), pausing fetches.", window.Start, window.End))
+	} else if !active && inMaintenanceWindow {
+		logInfo("Maintenance window over, resuming fetches.")
+	}
+	inMaintenanceWindow = active
+	return active
+}
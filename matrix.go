@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Matrix events can be up to 64KiB, so this leaves plenty of room for the
+// HTML copy of the message and the JSON around it.
+const matrixMessageLimit = 16000
+
+// matrixTxnCounter makes transaction IDs unique within a run; the start time
+// keeps them unique across restarts.
+var (
+	matrixTxnCounter atomic.Int64
+	matrixTxnPrefix  = fmt.Sprintf("ps-diff-%d", time.Now().UnixNano())
+)
+
+// MatrixNotifier sends messages to a Matrix room through a homeserver's
+// client-server API, as the user AccessToken belongs to. With HTML set the
+// message also carries an HTML version that clients show with classes in bold.
+type MatrixNotifier struct {
+	HomeserverURL string
+	AccessToken   string
+	RoomID        string
+	HTML          bool
+}
+
+func (m *MatrixNotifier) Notify(ctx context.Context, message Message) error {
+	chunks := splitMessage(message.String(), matrixMessageLimit)
+	for i, chunk := range chunks {
+		if err := m.send(ctx, chunk); err != nil {
+			return fmt.Errorf("sending Matrix message %d of %d: %w", i+1, len(chunks), err)
+		}
+	}
+	logSuccess(fmt.Sprintf("Matrix notification sent (%d message(s))!", len(chunks)))
+	return nil
+}
+
+// matrixMessage is an m.room.message event's content.
+type matrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+}
+
+func (m *MatrixNotifier) send(ctx context.Context, text string) error {
+	content := matrixMessage{MsgType: "m.text", Body: text}
+	if m.HTML {
+		content.Format = "org.matrix.custom.html"
+		content.FormattedBody = matrixHTML(text)
+	}
+	body, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+
+	txnID := fmt.Sprintf("%s-%d", matrixTxnPrefix, matrixTxnCounter.Add(1))
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimSuffix(m.HomeserverURL, "/"), url.PathEscape(m.RoomID), url.PathEscape(txnID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+m.AccessToken)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	var matrixErr struct {
+		ErrCode string `json:"errcode"`
+		Error   string `json:"error"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	json.Unmarshal(data, &matrixErr)
+	switch matrixErr.ErrCode {
+	case "M_UNKNOWN_TOKEN", "M_MISSING_TOKEN":
+		return fmt.Errorf("Matrix rejected the access token, check matrix_access_token: %s", matrixErr.Error)
+	case "M_FORBIDDEN":
+		return fmt.Errorf("not allowed to post in %s, make sure the account has joined the room: %s", m.RoomID, matrixErr.Error)
+	}
+	return fmt.Errorf("Matrix returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+}
+
+// matrixHTML renders a message as HTML, with class headers in bold and the
+// indented changes under them as a list.
+func matrixHTML(message string) string {
+	var sb strings.Builder
+	inList := false
+	for _, line := range strings.Split(message, "\n") {
+		indented := strings.HasPrefix(line, changeIndent)
+		if indented && !inList {
+			sb.WriteString("<ul>")
+		} else if !indented && inList {
+			sb.WriteString("</ul>")
+		}
+		inList = indented
+
+		switch {
+		case indented:
+			fmt.Fprintf(&sb, "<li>%s</li>", html.EscapeString(strings.TrimPrefix(line, changeIndent)))
+		case line != "":
+			fmt.Fprintf(&sb, "<b>%s</b><br>", html.EscapeString(line))
+		}
+	}
+	if inList {
+		sb.WriteString("</ul>")
+	}
+	return sb.String()
+}
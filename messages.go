@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// MessageTemplates holds the text/template used for each kind of assignment
+// change. Every template gets a changeMessage.
+type MessageTemplates struct {
+	GradeChanged      string `json:"grade_changed" yaml:"grade_changed"`
+	AssignmentGraded  string `json:"assignment_graded" yaml:"assignment_graded"`
+	NewAssignment     string `json:"new_assignment" yaml:"new_assignment"`
+	AssignmentRemoved string `json:"assignment_removed" yaml:"assignment_removed"`
+}
+
+var defaultMessageTemplates = MessageTemplates{
+	GradeChanged:      "Grade changed for assignment '{{.Name}}' in class {{.ClassName}}: {{.OldGrade}} -> {{.NewGrade}}",
+	AssignmentGraded:  "Assignment '{{.Name}}' in class {{.ClassName}} was graded: {{.NewGrade}}",
+	NewAssignment:     "New assignment added: '{{.Name}}' in class {{.ClassName}} with grade {{.NewGrade}}",
+	AssignmentRemoved: "Assignment removed: '{{.Name}}' from class {{.ClassName}}",
+}
+
+// changeMessage is the data a message template is rendered with. Grades are
+// already formatted, e.g. "18/20 (90%)".
+type changeMessage struct {
+	Name      string
+	ClassName string
+	OldGrade  string
+	NewGrade  string
+}
+
+// messageTemplateNames lists the templates in the order they're validated.
+var messageTemplateNames = []string{"grade_changed", "assignment_graded", "new_assignment", "assignment_removed"}
+
+// byName returns the templates keyed by their config names.
+func (t MessageTemplates) byName() map[string]string {
+	return map[string]string{
+		"grade_changed":      t.GradeChanged,
+		"assignment_graded":  t.AssignmentGraded,
+		"new_assignment":     t.NewAssignment,
+		"assignment_removed": t.AssignmentRemoved,
+	}
+}
+
+// validate checks that every template parses.
+func (t MessageTemplates) validate() []string {
+	var problems []string
+	templates := t.byName()
+	for _, name := range messageTemplateNames {
+		if _, err := template.New(name).Parse(templates[name]); err != nil {
+			problems = append(problems, fmt.Sprintf("message_templates.%s: %s", name, err))
+		}
+	}
+	return problems
+}
+
+// renderMessage renders the configured template called name with data. If it
+// fails, e.g. by naming a field that doesn't exist, the error is logged and
+// the default template is used instead.
+func renderMessage(name string, data changeMessage) string {
+	message, err := executeTemplate(name, config.MessageTemplates.byName()[name], data)
+	if err != nil {
+		logError(fmt.Sprintf("Failed to render message template %s, using the default: %s", name, err))
+		message, _ = executeTemplate(name, defaultMessageTemplates.byName()[name], data)
+	}
+	return message
+}
+
+func executeTemplate(name, text string, data changeMessage) (string, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// defaultChangeMarkers are the emoji used when change_emoji is enabled.
+var defaultChangeMarkers = map[string]string{
+	"increase": "⬆️",
+	"decrease": "⬇️",
+	"new":      "🆕",
+	"removed":  "❌",
+}
+
+// changeMarker returns the marker configured for a kind of change ("increase",
+// "decrease", "new" or "removed") followed by a space, or "" if there's none.
+// change_markers overrides the change_emoji preset.
+func changeMarker(kind string) string {
+	marker, ok := config.ChangeMarkers[kind]
+	if !ok && config.ChangeEmoji {
+		marker = defaultChangeMarkers[kind]
+	}
+	if marker == "" {
+		return ""
+	}
+	return marker + " "
+}
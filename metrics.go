@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Counters and gauges served on /metrics in the Prometheus text format.
+var (
+	pollsTotal                atomic.Int64
+	fetchFailuresTotal        atomic.Int64
+	loginFailuresTotal        atomic.Int64
+	notificationsSentTotal    atomic.Int64
+	notificationFailuresTotal atomic.Int64
+	lastFetchLatency          atomic.Int64 // nanoseconds
+
+	lowestGradesMu sync.Mutex
+	lowestGrades   = make(map[int64]float64) // per student ID
+)
+
+// recordLowestGrade updates the lowest numeric class grade for a student.
+func recordLowestGrade(studentID int64, classes []Class) {
+	lowest, found := 0.0, false
+	for _, class := range classes {
+		if value, ok := parseGrade(classGrade(class)); ok && (!found || value < lowest) {
+			lowest, found = value, true
+		}
+	}
+
+	lowestGradesMu.Lock()
+	defer lowestGradesMu.Unlock()
+	if found {
+		lowestGrades[studentID] = lowest
+	} else {
+		delete(lowestGrades, studentID)
+	}
+}
+
+// metric is the current value of one counter or gauge, shared by the
+// /metrics page and the OTLP exporter. Each point may carry labels.
+type metric struct {
+	Name   string
+	Kind   string // "counter" or "gauge"
+	Help   string
+	Points []metricPoint
+}
+
+type metricPoint struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// collectMetrics snapshots every metric.
+func collectMetrics() []metric {
+	single := func(name, kind, help string, value float64) metric {
+		return metric{Name: name, Kind: kind, Help: help, Points: []metricPoint{{Value: value}}}
+	}
+	last := 0.0
+	if nanos := lastSuccessfulRun.Load(); nanos != 0 {
+		last = float64(time.Unix(0, nanos).Unix())
+	}
+	metrics := []metric{
+		single("ps_notifier_polls_total", "counter", "PowerSchool polls started.", float64(pollsTotal.Load())),
+		single("ps_notifier_fetch_failures_total", "counter", "Polls where logging in or fetching student data failed.", float64(fetchFailuresTotal.Load())),
+		single("ps_notifier_login_failures_total", "counter", "Polls where PowerSchool rejected the login.", float64(loginFailuresTotal.Load())),
+		single("ps_notifier_notifications_sent_total", "counter", "Notifications delivered.", float64(notificationsSentTotal.Load())),
+		single("ps_notifier_notification_failures_total", "counter", "Notification delivery attempts that failed.", float64(notificationFailuresTotal.Load())),
+		single("ps_notifier_last_success_timestamp_seconds", "gauge", "When the last poll finished successfully.", last),
+		single("ps_notifier_fetch_latency_seconds", "gauge", "How long the last PowerSchool fetch took.", time.Duration(lastFetchLatency.Load()).Seconds()),
+	}
+
+	lowest := metric{Name: "ps_notifier_lowest_class_grade", Kind: "gauge", Help: "Lowest numeric class grade per student."}
+	lowestGradesMu.Lock()
+	for studentID, grade := range lowestGrades {
+		lowest.Points = append(lowest.Points, metricPoint{
+			Labels: map[string]string{"student_id": strconv.FormatInt(studentID, 10)},
+			Value:  grade,
+		})
+	}
+	lowestGradesMu.Unlock()
+	sort.Slice(lowest.Points, func(i, j int) bool {
+		return lowest.Points[i].Labels["student_id"] < lowest.Points[j].Labels["student_id"]
+	})
+	return append(metrics, lowest)
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	for _, m := range collectMetrics() {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.Name, m.Help, m.Name, m.Kind)
+		for _, point := range m.Points {
+			labels := make([]string, 0, len(point.Labels))
+			for name, value := range point.Labels {
+				labels = append(labels, fmt.Sprintf("%s=%q", name, value))
+			}
+			sort.Strings(labels)
+			if len(labels) > 0 {
+				fmt.Fprintf(w, "%s{%s} %g\n", m.Name, strings.Join(labels, ","), point.Value)
+			} else {
+				fmt.Fprintf(w, "%s %g\n", m.Name, point.Value)
+			}
+		}
+	}
+}
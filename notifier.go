@@ -0,0 +1,487 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// httpClient is shared by the notifiers so a hung server can't block a poll
+// forever. Its timeout comes from the http_timeout setting.
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// Notifier delivers a change summary to some destination (Discord, email, ...).
+type Notifier interface {
+	Notify(ctx context.Context, message Message) error
+}
+
+// Message is a notification, kept line by line so notifiers can tell which
+// way each change went (e.g. to color a Discord embed or raise a Pushover
+// priority) without re-parsing the text.
+type Message struct {
+	Lines []MessageLine
+}
+
+// MessageLine is one line of a Message. Direction is 1 if the line is a grade
+// that went up, -1 if it's one that went down, and 0 otherwise.
+type MessageLine struct {
+	Text      string
+	Direction int `json:",omitempty"`
+}
+
+// textMessage makes a Message out of plain text with no grade changes in it.
+func textMessage(text string) Message {
+	if text == "" {
+		return Message{}
+	}
+	var message Message
+	for _, line := range strings.Split(text, "\n") {
+		message.Lines = append(message.Lines, MessageLine{Text: line})
+	}
+	return message
+}
+
+// String returns the message's text.
+func (m Message) String() string {
+	texts := make([]string, len(m.Lines))
+	for i, line := range m.Lines {
+		texts[i] = line.Text
+	}
+	return strings.Join(texts, "\n")
+}
+
+// dropped reports whether a grade went down in any line of the message.
+func (m Message) dropped() bool {
+	for _, line := range m.Lines {
+		if line.Direction < 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixed returns a copy of m with prefix added to its first line.
+func (m Message) prefixed(prefix string) Message {
+	if len(m.Lines) == 0 {
+		return m
+	}
+	lines := slices.Clone(m.Lines)
+	lines[0].Text = prefix + lines[0].Text
+	return Message{Lines: lines}
+}
+
+// UnmarshalJSON also accepts the plain strings queued by older versions.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*m = textMessage(text)
+		return nil
+	}
+	type plain Message
+	return json.Unmarshal(data, (*plain)(m))
+}
+
+// newNotifier builds the notifiers enabled in the config. Each one gets its own
+// NotificationQueue so a failing channel doesn't hold up or duplicate delivery
+// to the others.
+func newNotifier(cfg Config) (Notifier, error) {
+	var notifiers MultiNotifier
+	for _, name := range cfg.enabledNotifiers() {
+		backend, err := newBackendNotifier(cfg, name)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, &NotificationQueue{
+			Notifier: backend,
+			Cooldown: time.Duration(cfg.NotificationFailureCooldown),
+			File:     suffixFilePath(cfg.NotificationQueueFile, name),
+		})
+	}
+
+	var notifier Notifier = notifiers
+	if len(notifiers) == 1 {
+		notifier = notifiers[0]
+	}
+	notifier = scheduleNotifier(cfg, notifier, "")
+
+	if len(cfg.ClassWebhooks) == 0 {
+		return notifier, nil
+	}
+	router := &RoutingNotifier{Default: notifier, Routes: make(map[string]Notifier)}
+	routes := make([]string, 0, len(cfg.ClassWebhooks))
+	for route := range cfg.ClassWebhooks {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for i, route := range routes {
+		discord, _ := newBackendNotifier(cfg, "discord")
+		discord.(*DiscordNotifier).WebhookURL = cfg.ClassWebhooks[route]
+		suffix := fmt.Sprintf("route%d", i+1)
+		queue := &NotificationQueue{
+			Notifier: discord,
+			Cooldown: time.Duration(cfg.NotificationFailureCooldown),
+			File:     suffixFilePath(cfg.NotificationQueueFile, suffix),
+		}
+		router.Routes[route] = scheduleNotifier(cfg, queue, suffix)
+	}
+	return router, nil
+}
+
+// scheduleNotifier applies quiet hours, digest mode and de-duplication to
+// notifier. Each destination needs its own queue files, told apart by
+// fileSuffix.
+func scheduleNotifier(cfg Config, notifier Notifier, fileSuffix string) Notifier {
+	quietFile, digestFile, dedupeFile := cfg.QuietHoursFile, cfg.DigestFile, cfg.DedupeFile
+	if fileSuffix != "" {
+		quietFile = suffixFilePath(quietFile, fileSuffix)
+		digestFile = suffixFilePath(digestFile, fileSuffix)
+		dedupeFile = suffixFilePath(dedupeFile, fileSuffix)
+	}
+
+	if cfg.quietHoursEnabled() {
+		notifier = &QuietHoursNotifier{
+			Notifier: notifier,
+			Schedule: cfg.quietSchedule(),
+			File:     quietFile,
+			Students: cfg.studentQuietSchedules(),
+		}
+	}
+	if cfg.NotifyMode == "digest" {
+		notifier = &DigestNotifier{Notifier: notifier, Time: cfg.DigestTime, File: digestFile}
+	}
+	if cfg.DedupeWindow > 0 {
+		notifier = &DedupeNotifier{Notifier: notifier, Window: time.Duration(cfg.DedupeWindow), File: dedupeFile}
+	}
+	return notifier
+}
+
+func newBackendNotifier(cfg Config, name string) (Notifier, error) {
+	switch name {
+	case "discord":
+		return &DiscordNotifier{
+			WebhookURL:         cfg.DiscordWebhookURL,
+			Embeds:             cfg.DiscordEmbeds,
+			Username:           cfg.DiscordUsername,
+			AvatarURL:          cfg.DiscordAvatarURL,
+			FallbackWebhookURL: cfg.FallbackWebhookURL,
+			FallbackAfter:      time.Duration(cfg.FallbackAfterRateLimit),
+			RecoveryChecks:     cfg.PrimaryRecoveryChecks,
+		}, nil
+	case "slack":
+		return &SlackNotifier{WebhookURL: cfg.SlackWebhookURL}, nil
+	case "email":
+		return &EmailNotifier{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.EmailFrom,
+			To:       cfg.EmailTo,
+		}, nil
+	case "telegram":
+		return &TelegramNotifier{BotToken: cfg.TelegramBotToken, ChatID: cfg.TelegramChatID}, nil
+	case "pushover":
+		return &PushoverNotifier{Token: cfg.PushoverToken, User: cfg.PushoverUser, Title: cfg.PushoverTitle}, nil
+	case "webhook":
+		return &GenericWebhookNotifier{
+			URL:     cfg.WebhookURL,
+			Method:  cfg.WebhookMethod,
+			Headers: cfg.WebhookHeaders,
+			Body:    cfg.WebhookBody,
+		}, nil
+	case "ntfy":
+		return &NtfyNotifier{
+			TopicURL: cfg.NtfyURL,
+			Title:    cfg.NtfyTitle,
+			Priority: cfg.NtfyPriority,
+			Token:    cfg.NtfyToken,
+		}, nil
+	case "matrix":
+		return &MatrixNotifier{
+			HomeserverURL: cfg.MatrixHomeserverURL,
+			AccessToken:   cfg.MatrixAccessToken,
+			RoomID:        cfg.MatrixRoomID,
+			HTML:          cfg.MatrixHTML,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown notifier %q", name)
+	}
+}
+
+var testNotificationMessage = textMessage("Test notification from powerschool-notifier")
+
+// sendTestNotification sends a test message straight through every enabled
+// backend and class webhook, skipping the queues, quiet hours and digest so a
+// failure is reported right away instead of being held for a retry.
+func sendTestNotification(ctx context.Context, cfg Config) error {
+	var errs []error
+	for _, name := range cfg.enabledNotifiers() {
+		backend, err := newBackendNotifier(cfg, name)
+		if err != nil {
+			return err
+		}
+		if err := backend.Notify(ctx, testNotificationMessage); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	routes := make([]string, 0, len(cfg.ClassWebhooks))
+	for route := range cfg.ClassWebhooks {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		discord, _ := newBackendNotifier(cfg, "discord")
+		discord.(*DiscordNotifier).WebhookURL = cfg.ClassWebhooks[route]
+		if err := discord.Notify(ctx, testNotificationMessage); err != nil {
+			errs = append(errs, fmt.Errorf("class webhook for %q: %w", route, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MultiNotifier sends every message to all of its notifiers.
+type MultiNotifier []Notifier
+
+// Notify tries every notifier, even after one fails, and returns the
+// combined errors.
+func (m MultiNotifier) Notify(ctx context.Context, message Message) error {
+	var errs []error
+	for _, notifier := range m {
+		if err := notifier.Notify(ctx, message); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m MultiNotifier) Flush(ctx context.Context) {
+	for _, notifier := range m {
+		flushNotifier(ctx, notifier)
+	}
+}
+
+// lockedNotifier serializes messages to a Notifier, since the queues and
+// schedules behind it aren't safe to use from several goroutines at once.
+type lockedNotifier struct {
+	mu       sync.Mutex
+	Notifier Notifier
+}
+
+func (l *lockedNotifier) Notify(ctx context.Context, message Message) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.Notifier.Notify(ctx, message)
+}
+
+func (l *lockedNotifier) NotifyRoute(ctx context.Context, route string, message Message) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return notifyRoute(ctx, l.Notifier, route, message)
+}
+
+// RouteNotifier is implemented by notifiers that can send a class's changes
+// somewhere other than their default destination.
+type RouteNotifier interface {
+	NotifyRoute(ctx context.Context, route string, message Message) error
+}
+
+// notifyRoute sends message to route's destination if n supports routing and
+// route isn't "", and to n's default destination otherwise.
+func notifyRoute(ctx context.Context, n Notifier, route string, message Message) error {
+	if rn, ok := n.(RouteNotifier); ok && route != "" {
+		return rn.NotifyRoute(ctx, route, message)
+	}
+	return n.Notify(ctx, message)
+}
+
+// RoutingNotifier sends messages to Default, except for classes that have
+// their own Discord webhook in Routes, keyed by their class_webhooks entry.
+type RoutingNotifier struct {
+	Default Notifier
+	Routes  map[string]Notifier
+}
+
+func (r *RoutingNotifier) Notify(ctx context.Context, message Message) error {
+	return r.Default.Notify(ctx, message)
+}
+
+func (r *RoutingNotifier) NotifyRoute(ctx context.Context, route string, message Message) error {
+	if n, ok := r.Routes[route]; ok {
+		return n.Notify(ctx, message)
+	}
+	return r.Default.Notify(ctx, message)
+}
+
+func (r *RoutingNotifier) Flush(ctx context.Context) {
+	flushNotifier(ctx, r.Default)
+	for _, n := range r.Routes {
+		flushNotifier(ctx, n)
+	}
+}
+
+// splitMessage splits message on line boundaries into chunks of at most limit
+// characters. A single line longer than limit is truncated.
+func splitMessage(message string, limit int) []string {
+	var chunks []string
+	var current strings.Builder
+	currentLen := 0
+
+	for _, line := range strings.Split(message, "\n") {
+		line = truncateRunes(line, limit)
+		lineLen := len([]rune(line))
+
+		if currentLen > 0 && currentLen+1+lineLen > limit {
+			chunks = append(chunks, current.String())
+			current.Reset()
+			currentLen = 0
+		}
+		if currentLen > 0 {
+			current.WriteString("\n")
+			currentLen++
+		}
+		current.WriteString(line)
+		currentLen += lineLen
+	}
+	if currentLen > 0 {
+		chunks = append(chunks, current.String())
+	}
+	return chunks
+}
+
+// Flusher is implemented by notifiers that need a chance to do work on every
+// poll even when there's nothing new to send, e.g. retrying held-back messages.
+type Flusher interface {
+	Flush(ctx context.Context)
+}
+
+// flushNotifier calls Flush on notifiers that implement Flusher.
+func flushNotifier(ctx context.Context, notifier Notifier) {
+	if flusher, ok := notifier.(Flusher); ok {
+		flusher.Flush(ctx)
+	}
+}
+
+// errRejected matches, with errors.Is, a notification the destination refused
+// outright (e.g. a 4xx response), so trying it again won't help.
+var errRejected = errors.New("notification rejected")
+
+// NotificationQueue wraps a Notifier, holding on to messages that failed to
+// deliver and retrying them in order once Cooldown has passed. A message the
+// destination rejects is dropped instead. Undelivered messages are kept in
+// File so a restart doesn't lose them.
+type NotificationQueue struct {
+	Notifier Notifier
+	Cooldown time.Duration
+	File     string
+
+	loaded      bool
+	pending     []Message
+	lastFailure time.Time
+}
+
+func loadPendingNotifications(filename string) []Message {
+	var messages []Message
+
+	bytesData, err := os.ReadFile(filename)
+	if err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(bytesData, &messages); err != nil {
+		logWarning("Could not parse pending notifications, starting a fresh queue: " + err.Error())
+		return nil
+	}
+	return messages
+}
+
+func savePendingNotifications(filename string, messages []Message) error {
+	bytesData, err := json.MarshalIndent(messages, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filename, bytesData)
+}
+
+// load reads messages left pending by a previous run, once.
+func (q *NotificationQueue) load() {
+	if q.loaded {
+		return
+	}
+	q.loaded = true
+	if q.File != "" {
+		q.pending = append(loadPendingNotifications(q.File), q.pending...)
+	}
+}
+
+func (q *NotificationQueue) save() {
+	if q.File == "" {
+		return
+	}
+	if err := savePendingNotifications(q.File, q.pending); err != nil {
+		logError("Failed to save pending notifications: " + err.Error())
+	}
+}
+
+// Notify queues message and tries to deliver everything pending. Failures are
+// logged and retried later, so it never returns an error.
+func (q *NotificationQueue) Notify(ctx context.Context, message Message) error {
+	if len(message.Lines) == 0 {
+		return nil
+	}
+
+	q.load()
+	q.pending = append(q.pending, message)
+	q.save()
+	q.deliverPending(ctx)
+	return nil
+}
+
+// Flush retries pending messages if the cooldown has passed.
+func (q *NotificationQueue) Flush(ctx context.Context) {
+	flushNotifier(ctx, q.Notifier)
+	q.load()
+	q.deliverPending(ctx)
+}
+
+// deliverPending delivers queued notifications in order, stopping at the first
+// failure so the rest are retried after the cooldown.
+func (q *NotificationQueue) deliverPending(ctx context.Context) {
+	if len(q.pending) == 0 {
+		return
+	}
+
+	if remaining := q.Cooldown - time.Since(q.lastFailure); remaining > 0 {
+		logWarning(fmt.Sprintf("Notification delivery cooling down after a failure; %d pending, retrying in %s.",
+			len(q.pending), remaining.Round(time.Second)))
+		return
+	}
+
+	for len(q.pending) > 0 {
+		if err := q.Notifier.Notify(ctx, q.pending[0]); errors.Is(err, errRejected) {
+			// Holding on to it would block everything queued behind it for good
+			notificationFailuresTotal.Add(1)
+			logError("Dropping notification the destination rejected: " + err.Error())
+			q.pending = q.pending[1:]
+			q.save()
+			continue
+		} else if err != nil {
+			notificationFailuresTotal.Add(1)
+			q.lastFailure = time.Now()
+			logError(fmt.Sprintf("Error sending notification (%d pending, next attempt in %s): %s",
+				len(q.pending), q.Cooldown, err.Error()))
+			return
+		}
+		notificationsSentTotal.Add(1)
+		q.pending = q.pending[1:]
+		q.save()
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ntfy turns messages longer than this many bytes into attachments, so longer
+// ones are split instead.
+const ntfyMessageLimit = 4096
+
+// NtfyNotifier publishes messages to an ntfy topic URL, e.g.
+// https://ntfy.sh/my-grades or a self-hosted server. Title and Priority are
+// sent as headers if set, and Token as a bearer token for protected topics.
+type NtfyNotifier struct {
+	TopicURL string
+	Title    string
+	Priority string
+	Token    string
+}
+
+func (n *NtfyNotifier) Notify(ctx context.Context, message Message) error {
+	chunks := splitMessage(message.String(), ntfyMessageLimit)
+	for i, chunk := range chunks {
+		if err := n.publish(ctx, chunk); err != nil {
+			return fmt.Errorf("publishing ntfy message %d of %d: %w", i+1, len(chunks), err)
+		}
+	}
+	logSuccess(fmt.Sprintf("ntfy notification sent (%d message(s))!", len(chunks)))
+	return nil
+}
+
+func (n *NtfyNotifier) publish(ctx context.Context, text string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.TopicURL, strings.NewReader(text))
+	if err != nil {
+		return err
+	}
+	if n.Title != "" {
+		req.Header.Set("Title", n.Title)
+	}
+	if n.Priority != "" {
+		req.Header.Set("Priority", n.Priority)
+	}
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("ntfy returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
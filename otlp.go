@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// otlpExporter pushes the same counters and gauges served on /metrics to an
+// OpenTelemetry collector every Interval, as OTLP/HTTP JSON.
+type otlpExporter struct {
+	Endpoint string // e.g. http://localhost:4318/v1/metrics
+	Headers  map[string]string
+	Interval time.Duration
+
+	start time.Time
+}
+
+// OTLP JSON encoding of an ExportMetricsServiceRequest, limited to the parts
+// used here. 64-bit integers are strings, as in the protobuf JSON mapping.
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Sum         *otlpSum   `json:"sum,omitempty"`
+	Gauge       *otlpGauge `json:"gauge,omitempty"`
+}
+
+// Cumulative sums, since the counters only ever go up from process start.
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsDouble          float64         `json:"asDouble"`
+}
+
+type otlpAttribute struct {
+	Key   string        `json:"key"`
+	Value otlpAttrValue `json:"value"`
+}
+
+type otlpAttrValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+const otlpCumulative = 2 // AGGREGATION_TEMPORALITY_CUMULATIVE
+
+// otlpPayload converts metrics to an OTLP request, with counters as
+// cumulative sums since start.
+func otlpPayload(metrics []metric, start, now time.Time) otlpRequest {
+	startNanos := strconv.FormatInt(start.UnixNano(), 10)
+	nowNanos := strconv.FormatInt(now.UnixNano(), 10)
+
+	var converted []otlpMetric
+	for _, m := range metrics {
+		var points []otlpDataPoint
+		for _, point := range m.Points {
+			dataPoint := otlpDataPoint{TimeUnixNano: nowNanos, AsDouble: point.Value}
+			names := make([]string, 0, len(point.Labels))
+			for name := range point.Labels {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				dataPoint.Attributes = append(dataPoint.Attributes,
+					otlpAttribute{Key: name, Value: otlpAttrValue{StringValue: point.Labels[name]}})
+			}
+			if m.Kind == "counter" {
+				dataPoint.StartTimeUnixNano = startNanos
+			}
+			points = append(points, dataPoint)
+		}
+
+		out := otlpMetric{Name: m.Name, Description: m.Help}
+		if m.Kind == "counter" {
+			out.Sum = &otlpSum{DataPoints: points, AggregationTemporality: otlpCumulative, IsMonotonic: true}
+		} else {
+			out.Gauge = &otlpGauge{DataPoints: points}
+		}
+		converted = append(converted, out)
+	}
+
+	return otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			{Key: "service.name", Value: otlpAttrValue{StringValue: "ps-diff"}},
+		}},
+		ScopeMetrics: []otlpScopeMetrics{{Scope: otlpScope{Name: "ps-diff"}, Metrics: converted}},
+	}}}
+}
+
+// run exports every Interval until ctx is cancelled.
+func (e *otlpExporter) run(ctx context.Context) {
+	e.start = time.Now()
+	ticker := time.NewTicker(e.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.export(ctx); err != nil {
+				logWarning("Failed to export metrics over OTLP: " + err.Error())
+			}
+		}
+	}
+}
+
+func (e *otlpExporter) export(ctx context.Context) error {
+	jsonData, err := json.Marshal(otlpPayload(collectMetrics(), e.start, time.Now()))
+	if err != nil {
+		return fmt.Errorf("marshaling JSON: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("collector returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
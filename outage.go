@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Consecutive failed fetches and when we last notified about them.
+var (
+	consecutiveFetchFailures int
+	lastFetchFailureAlert    time.Time
+)
+
+// When the circuit breaker is open, polls are skipped until circuitOpenUntil.
+var (
+	circuitOpen      bool
+	circuitOpenUntil time.Time
+)
+
+var errCircuitOpen = errors.New("too many failed polls, PowerSchool circuit breaker is open")
+
+// circuitAllowsFetch reports whether a poll may contact PowerSchool. Once the
+// cooldown has passed, one poll is let through to see if it's back.
+func circuitAllowsFetch() bool {
+	if !circuitOpen {
+		return true
+	}
+	if remaining := time.Until(circuitOpenUntil); remaining > 0 {
+		logWarning(fmt.Sprintf("Skipping poll, PowerSchool circuit breaker is open for another %s.", remaining.Round(time.Second)))
+		return false
+	}
+	logInfo("PowerSchool circuit breaker cooldown is over, trying a poll.")
+	return true
+}
+
+// tripCircuit opens the circuit breaker once CircuitBreakerAfter polls in a
+// row have failed, or re-opens it when the poll after the cooldown fails too.
+func tripCircuit(ctx context.Context, notifier Notifier) {
+	if config.CircuitBreakerAfter <= 0 || consecutiveFetchFailures < config.CircuitBreakerAfter {
+		return
+	}
+	cooldown := time.Duration(config.CircuitBreakerCooldown)
+	circuitOpenUntil = time.Now().Add(cooldown)
+	if circuitOpen {
+		logWarning(fmt.Sprintf("PowerSchool is still failing, pausing polls for another %s.", cooldown))
+		return
+	}
+	circuitOpen = true
+	msg := fmt.Sprintf("Pausing PowerSchool polls for %s after %d failed polls in a row.", cooldown, consecutiveFetchFailures)
+	logWarning(msg)
+	if config.CircuitBreakerNotify {
+		if err := notifier.Notify(ctx, textMessage(msg)); err != nil {
+			logError("Failed to send circuit breaker notice: " + err.Error())
+		}
+	}
+}
+
+// resetCircuit closes the circuit breaker after a successful poll.
+func resetCircuit(ctx context.Context, notifier Notifier) {
+	if !circuitOpen {
+		return
+	}
+	circuitOpen = false
+	circuitOpenUntil = time.Time{}
+	msg := "PowerSchool polls resumed."
+	logInfo(msg)
+	if config.CircuitBreakerNotify {
+		if err := notifier.Notify(ctx, textMessage(msg)); err != nil {
+			logError("Failed to send circuit breaker notice: " + err.Error())
+		}
+	}
+}
+
+// recordFetchFailure counts a failed fetch and, once FetchFailureAlertAfter
+// polls in a row have failed, notifies at most once per FetchFailureAlertCooldown.
+func recordFetchFailure(ctx context.Context, notifier Notifier, err error) {
+	consecutiveFetchFailures++
+	tripCircuit(ctx, notifier)
+	if config.FetchFailureAlertAfter <= 0 || consecutiveFetchFailures < config.FetchFailureAlertAfter {
+		return
+	}
+	if !lastFetchFailureAlert.IsZero() && time.Since(lastFetchFailureAlert) < time.Duration(config.FetchFailureAlertCooldown) {
+		return
+	}
+
+	msg := fmt.Sprintf("Can't reach PowerSchool: %d polls in a row have failed as of %s.\nLast error: %s",
+		consecutiveFetchFailures, localNow().Format("2006-01-02 15:04 MST"), err.Error())
+	if notifyErr := notifier.Notify(ctx, textMessage(msg)); notifyErr != nil {
+		logError("Failed to send fetch failure alert: " + notifyErr.Error())
+		return
+	}
+	lastFetchFailureAlert = time.Now()
+}
+
+// recordFetchSuccess resets the failure count, letting the user know things
+// work again if we'd alerted about the failures.
+func recordFetchSuccess(ctx context.Context, notifier Notifier) {
+	resetCircuit(ctx, notifier)
+	if !lastFetchFailureAlert.IsZero() {
+		msg := fmt.Sprintf("PowerSchool is reachable again after %d failed polls.", consecutiveFetchFailures)
+		if err := notifier.Notify(ctx, textMessage(msg)); err != nil {
+			logError("Failed to send fetch recovery notice: " + err.Error())
+		}
+	}
+	consecutiveFetchFailures = 0
+	lastFetchFailureAlert = time.Time{}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// writePIDFile writes the current process ID to filename for init systems
+// that track the poller through a PID file. A file left behind by an earlier
+// run that didn't shut down cleanly is overwritten with a warning.
+func writePIDFile(filename string) error {
+	if old, err := os.ReadFile(filename); err == nil {
+		logWarning(fmt.Sprintf("Overwriting stale PID file %s (PID %s).", filename, strings.TrimSpace(string(old))))
+	}
+	return writeFileAtomic(filename, []byte(strconv.Itoa(os.Getpid())+"\n"))
+}
+
+// removePIDFile removes the PID file on shutdown, unless another process has
+// taken it over since.
+func removePIDFile(filename string) {
+	data, err := os.ReadFile(filename)
+	if err != nil || strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	if err := os.Remove(filename); err != nil {
+		logWarning("Could not remove PID file: " + err.Error())
+	}
+}
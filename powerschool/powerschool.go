@@ -1,9 +1,24 @@
 package powerschool
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/url"
+	"time"
 )
 
+// LoginError is returned when PowerSchool refuses to log in, e.g. because the
+// username or password is wrong. Retrying won't help.
+type LoginError struct {
+	Title       string
+	Description string
+}
+
+func (e *LoginError) Error() string {
+	return fmt.Sprintf("error: %s - %s", e.Title, e.Description)
+}
+
 func Client(url string) *PublicPortalServiceJSONPortType {
 	auth := DigestAuth{Login: "pearson", Password: "m0bApP5"}
 	if url[len(url)-1] != '/' {
@@ -12,15 +27,42 @@ func Client(url string) *PublicPortalServiceJSONPortType {
 	wsdl_url := fmt.Sprintf("%s/pearson-rest/services/PublicPortalServiceJSON?wsdl", url)
 	return NewPublicPortalServiceJSONPortType(wsdl_url, true, &auth)
 }
+// SetHeaders adds custom headers (e.g. an API gateway key) to every request.
+func (client *PublicPortalServiceJSONPortType) SetHeaders(headers map[string]string) {
+	client.client.headers = headers
+}
+// SetTimeout limits how long each request to PowerSchool may take. 0 means no limit.
+func (client *PublicPortalServiceJSONPortType) SetTimeout(timeout time.Duration) {
+	client.client.timeout = timeout
+}
+// SetProxy sends requests through the proxy chosen by proxy. By default the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used.
+func (client *PublicPortalServiceJSONPortType) SetProxy(proxy func(*http.Request) (*url.URL, error)) {
+	client.client.proxy = proxy
+}
+// SetContext makes later requests use ctx, so they're cancelled along with it.
+func (client *PublicPortalServiceJSONPortType) SetContext(ctx context.Context) {
+	client.client.ctx = ctx
+}
 func (client *PublicPortalServiceJSONPortType) CreateUserSessionAndStudent(username, password string) (*UserSessionVO, int64, error) {
+	session, studentIDs, err := client.CreateUserSession(username, password)
+	if err != nil {
+		return nil, 0, err
+	}
+	return session, studentIDs[0], nil
+}
+
+// CreateUserSession logs in and returns the session along with the IDs of
+// every student on the account.
+func (client *PublicPortalServiceJSONPortType) CreateUserSession(username, password string) (*UserSessionVO, []int64, error) {
 
 	PublicPortalLogin := LoginToPublicPortal{Username: username, Password: password}
 	response, err := client.LoginToPublicPortal(&PublicPortalLogin)
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, err
 	}
 	if response.Return_.MessageVOs != nil {
-		return nil, 0, fmt.Errorf("error: %s - %s", response.Return_.MessageVOs[0].Title, response.Return_.MessageVOs[0].Description)
+		return nil, nil, &LoginError{Title: response.Return_.MessageVOs[0].Title, Description: response.Return_.MessageVOs[0].Description}
 	}
 	newSession := UserSessionVO{
 		UserId:            response.Return_.UserSessionVO.UserId,
@@ -28,17 +70,48 @@ func (client *PublicPortalServiceJSONPortType) CreateUserSessionAndStudent(usern
 		ServerInfo:        &ServerInfo{ApiVersion: response.Return_.UserSessionVO.ServerInfo.ApiVersion},
 		ServerCurrentTime: response.Return_.UserSessionVO.ServerCurrentTime,
 		UserType:          response.Return_.UserSessionVO.UserType}
-	return &newSession, int64(response.Return_.UserSessionVO.StudentIDs[0]), nil
+	var studentIDs []int64
+	for _, id := range response.Return_.UserSessionVO.StudentIDs {
+		studentIDs = append(studentIDs, int64(id))
+	}
+	if len(studentIDs) == 0 {
+		return nil, nil, fmt.Errorf("error: no students on this account")
+	}
+	return &newSession, studentIDs, nil
 }
 func (client *PublicPortalServiceJSONPortType) GetStudent(username, password string) (*StudentDataVO, error) {
 	session, userID, err := client.CreateUserSessionAndStudent(username, password)
 	if err != nil {
 		return nil, err
 	}
-	studentDataArguments := GetStudentData{UserSessionVO: session, StudentIDs: []int64{userID}, Qil: &QueryIncludeListVO{Includes: []int32{1}}}
+	return client.GetStudentWithSession(session, userID)
+}
+
+// GetStudentWithSession fetches student data using an existing login session,
+// so callers can reuse a session instead of logging in for every request.
+func (client *PublicPortalServiceJSONPortType) GetStudentWithSession(session *UserSessionVO, studentID int64) (*StudentDataVO, error) {
+	students, err := client.GetStudentsWithSession(session, []int64{studentID})
+	if err != nil {
+		return nil, err
+	}
+	return students[0], nil
+}
+
+// GetStudentsWithSession fetches data for several students on the account at once.
+func (client *PublicPortalServiceJSONPortType) GetStudentsWithSession(session *UserSessionVO, studentIDs []int64) ([]*StudentDataVO, error) {
+	studentDataArguments := GetStudentData{UserSessionVO: session, StudentIDs: studentIDs, Qil: &QueryIncludeListVO{Includes: []int32{1}}}
 	student, err := client.GetStudentData(&studentDataArguments)
 	if err != nil {
 		return nil, err
 	}
-	return student.Return_.StudentDataVOs[0], nil
+	if student.Return_ == nil {
+		return nil, fmt.Errorf("error: empty student data response")
+	}
+	if student.Return_.MessageVOs != nil {
+		return nil, fmt.Errorf("error: %s - %s", student.Return_.MessageVOs[0].Title, student.Return_.MessageVOs[0].Description)
+	}
+	if len(student.Return_.StudentDataVOs) == 0 {
+		return nil, fmt.Errorf("error: no student data returned")
+	}
+	return student.Return_.StudentDataVOs, nil
 }
\ No newline at end of file
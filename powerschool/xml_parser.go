@@ -2,6 +2,7 @@ package powerschool
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/tls"
@@ -13,6 +14,7 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 )
@@ -1093,9 +1095,13 @@ type DigestAuth struct {
 }
 
 type SOAPClient struct {
-	url  string
-	tls  bool
-	auth *DigestAuth
+	url     string
+	tls     bool
+	auth    *DigestAuth
+	headers map[string]string
+	timeout time.Duration
+	ctx     context.Context
+	proxy   func(*http.Request) (*url.URL, error)
 }
 
 func (b *SOAPBody) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
@@ -1179,18 +1185,28 @@ func (s *SOAPClient) Call(soapAction string, request, response interface{}) erro
 		return err
 	}
 	// AUTH
-	req, err := http.NewRequest("POST", s.url, nil)
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", s.url, nil)
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	s.setCustomHeaders(req)
+	proxy := s.proxy
+	if proxy == nil {
+		proxy = http.ProxyFromEnvironment
+	}
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{
 			InsecureSkipVerify: s.tls,
 		},
-		Dial: dialTimeout,
+		Proxy: proxy,
+		Dial:  dialTimeout,
 	}
-	client := &http.Client{Transport: tr}
+	client := &http.Client{Transport: tr, Timeout: s.timeout}
 	resp, err := client.Do(req)
 	if err != nil {
 		return err
@@ -1202,7 +1218,7 @@ func (s *SOAPClient) Call(soapAction string, request, response interface{}) erro
 	digest["username"] = s.auth.Login
 	digest["password"] = s.auth.Password
 	// SOAP
-	req, err = http.NewRequest("POST", s.url, buffer)
+	req, err = http.NewRequestWithContext(ctx, "POST", s.url, buffer)
 	if err != nil {
 		return err
 	}
@@ -1213,6 +1229,7 @@ func (s *SOAPClient) Call(soapAction string, request, response interface{}) erro
 	}
 	req.Header.Set("Authorization", getDigestAuth(digest))
 	req.Header.Set("User-Agent", "gowsdl/0.1")
+	s.setCustomHeaders(req)
 	req.Close = true
 
 	res, err := client.Do(req)
@@ -1220,6 +1237,9 @@ func (s *SOAPClient) Call(soapAction string, request, response interface{}) erro
 		return err
 	}
 	defer res.Body.Close()
+	if len(s.headers) > 0 && (res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden) {
+		return fmt.Errorf("server rejected the request (%s); check the custom headers are correct and complete", res.Status)
+	}
 
 	rawbody, err := ioutil.ReadAll(res.Body)
 	if err != nil {
@@ -1244,6 +1264,12 @@ func (s *SOAPClient) Call(soapAction string, request, response interface{}) erro
 
 	return nil
 }
+func (s *SOAPClient) setCustomHeaders(req *http.Request) {
+	for name, value := range s.headers {
+		req.Header.Set(name, value)
+	}
+}
+
 func digestParts(resp *http.Response) map[string]string {
 	result := map[string]string{}
 	if len(resp.Header["Www-Authenticate"]) > 0 {
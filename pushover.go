@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// pushoverAPIURL is a variable so tests can point it at a fake server.
+var pushoverAPIURL = "https://api.pushover.net/1/messages.json"
+
+// Pushover rejects messages longer than this many characters.
+const pushoverMessageLimit = 1024
+
+// Pushover priorities: normal, and high, which bypasses the user's quiet hours
+const (
+	pushoverPriorityNormal = 0
+	pushoverPriorityHigh   = 1
+)
+
+// PushoverNotifier sends messages through the Pushover API. Messages with a
+// grade drop or a low grade alert are sent at high priority.
+type PushoverNotifier struct {
+	Token string
+	User  string
+	Title string
+}
+
+type pushoverResponse struct {
+	Status int      `json:"status"`
+	Errors []string `json:"errors"`
+}
+
+func (p *PushoverNotifier) Notify(ctx context.Context, message Message) error {
+	priority := pushoverPriorityNormal
+	if message.dropped() {
+		priority = pushoverPriorityHigh
+	}
+
+	chunks := splitMessage(message.String(), pushoverMessageLimit)
+	for i, chunk := range chunks {
+		if err := p.send(ctx, chunk, priority); err != nil {
+			return fmt.Errorf("sending Pushover message %d of %d: %w", i+1, len(chunks), err)
+		}
+	}
+	logSuccess(fmt.Sprintf("Pushover notification sent (%d message(s))!", len(chunks)))
+	return nil
+}
+
+func (p *PushoverNotifier) send(ctx context.Context, text string, priority int) error {
+	form := url.Values{
+		"token":    {p.Token},
+		"user":     {p.User},
+		"message":  {text},
+		"priority": {strconv.Itoa(priority)},
+	}
+	if p.Title != "" {
+		form.Set("title", p.Title)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pushoverAPIURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result pushoverResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("pushover returned %s", resp.Status)
+	}
+	if result.Status != 1 {
+		return fmt.Errorf("pushover returned %s: %s", resp.Status, strings.Join(result.Errors, "; "))
+	}
+	return nil
+}
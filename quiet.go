@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// QuietSchedule says when notifications are held: during Hours every day, all
+// day on weekends if Weekends is set, and all day on Holidays (YYYY-MM-DD).
+type QuietSchedule struct {
+	Hours    string // "HH:MM-HH:MM", may wrap past midnight
+	Weekends bool
+	Holidays []string
+}
+
+// QuietHoursNotifier holds notifications while Schedule is quiet, persisting
+// them to File, and delivers them once it isn't. Students with their own
+// schedule in Students, keyed by their student_settings entry, get their own
+// hold file next to File.
+type QuietHoursNotifier struct {
+	Notifier Notifier
+	Schedule QuietSchedule
+	File     string
+	Students map[string]QuietSchedule
+}
+
+// parseQuietHours splits "22:00-07:00" into start and end times of day.
+func parseQuietHours(hours string) (start, end time.Time, err error) {
+	startText, endText, found := strings.Cut(hours, "-")
+	if !found {
+		return start, end, fmt.Errorf("quiet hours %q must look like 22:00-07:00", hours)
+	}
+	if start, err = time.Parse("15:04", strings.TrimSpace(startText)); err != nil {
+		return start, end, fmt.Errorf("quiet hours %q must look like 22:00-07:00", hours)
+	}
+	if end, err = time.Parse("15:04", strings.TrimSpace(endText)); err != nil {
+		return start, end, fmt.Errorf("quiet hours %q must look like 22:00-07:00", hours)
+	}
+	return start, end, nil
+}
+
+// validate reports what's wrong with the schedule's hours and holidays.
+func (s QuietSchedule) validate() []string {
+	var problems []string
+	if s.Hours != "" {
+		if _, _, err := parseQuietHours(s.Hours); err != nil {
+			problems = append(problems, "quiet_hours: "+err.Error())
+		}
+	}
+	for _, holiday := range s.Holidays {
+		if _, err := time.Parse("2006-01-02", holiday); err != nil {
+			problems = append(problems, fmt.Sprintf("holidays: %q must be a date like 2025-12-25", holiday))
+		}
+	}
+	return problems
+}
+
+// enabled reports whether the schedule is ever quiet.
+func (s QuietSchedule) enabled() bool {
+	return s.Hours != "" || s.Weekends || len(s.Holidays) > 0
+}
+
+// quiet reports whether now falls within the schedule.
+func (s QuietSchedule) quiet(now time.Time) bool {
+	if s.Weekends && (now.Weekday() == time.Saturday || now.Weekday() == time.Sunday) {
+		return true
+	}
+	for _, holiday := range s.Holidays {
+		if now.Format("2006-01-02") == holiday {
+			return true
+		}
+	}
+
+	start, end, err := parseQuietHours(s.Hours)
+	if err != nil {
+		return false
+	}
+	minute := now.Hour()*60 + now.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+	if startMinute <= endMinute {
+		return minute >= startMinute && minute < endMinute
+	}
+	return minute >= startMinute || minute < endMinute
+}
+
+// quietStudentKey is the context key for the student_settings entry of the
+// student a notification is about.
+type quietStudentKey struct{}
+
+// withQuietStudent marks notifications sent with ctx as being about the
+// student with the given student_settings entry.
+func withQuietStudent(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, quietStudentKey{}, key)
+}
+
+// scheduleFor returns the schedule and hold file for a notification sent with
+// ctx: the student's own, if they have one, otherwise the global ones.
+func (q *QuietHoursNotifier) scheduleFor(ctx context.Context) (QuietSchedule, string) {
+	if key, ok := ctx.Value(quietStudentKey{}).(string); ok {
+		if schedule, ok := q.Students[key]; ok {
+			return schedule, q.studentFile(key)
+		}
+	}
+	return q.Schedule, q.File
+}
+
+// studentFile is the hold file for the student_settings entry key, e.g.
+// quiet_hours_queue_sam.json.
+func (q *QuietHoursNotifier) studentFile(key string) string {
+	return suffixFilePath(q.File, strings.ReplaceAll(strings.ToLower(strings.TrimSpace(key)), " ", "_"))
+}
+
+func loadQuietQueue(filename string) []Message {
+	var messages []Message
+
+	bytesData, err := os.ReadFile(filename)
+	if err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(bytesData, &messages); err != nil {
+		logWarning("Could not parse quiet hours queue, starting a fresh one: " + err.Error())
+		return nil
+	}
+	return messages
+}
+
+func saveQuietQueue(filename string, messages []Message) error {
+	bytesData, err := json.MarshalIndent(messages, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filename, bytesData)
+}
+
+// Notify holds message during quiet hours, otherwise it sends anything held
+// and then message.
+func (q *QuietHoursNotifier) Notify(ctx context.Context, message Message) error {
+	if len(message.Lines) == 0 {
+		return nil
+	}
+
+	schedule, file := q.scheduleFor(ctx)
+	if schedule.quiet(localNow()) {
+		messages := append(loadQuietQueue(file), message)
+		if err := saveQuietQueue(file, messages); err != nil {
+			return fmt.Errorf("saving quiet hours queue: %w", err)
+		}
+		logInfo(fmt.Sprintf("Quiet hours, holding notification (%d waiting).", len(messages)))
+		return nil
+	}
+
+	q.deliverHeld(ctx, file)
+	return q.Notifier.Notify(ctx, message)
+}
+
+// Flush delivers held notifications, for everyone whose quiet hours are over.
+func (q *QuietHoursNotifier) Flush(ctx context.Context) {
+	flushNotifier(ctx, q.Notifier)
+	now := localNow()
+	if !q.Schedule.quiet(now) {
+		q.deliverHeld(ctx, q.File)
+	}
+	keys := make([]string, 0, len(q.Students))
+	for key := range q.Students {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !q.Students[key].quiet(now) {
+			q.deliverHeld(ctx, q.studentFile(key))
+		}
+	}
+}
+
+func (q *QuietHoursNotifier) deliverHeld(ctx context.Context, file string) {
+	messages := loadQuietQueue(file)
+	if len(messages) == 0 {
+		return
+	}
+
+	logInfo(fmt.Sprintf("Quiet hours over, sending %d held notification(s).", len(messages)))
+	for len(messages) > 0 {
+		if err := q.Notifier.Notify(ctx, messages[0]); err != nil {
+			logError("Failed to send held notification: " + err.Error())
+			break
+		}
+		messages = messages[1:]
+	}
+	if err := saveQuietQueue(file, messages); err != nil {
+		logError("Failed to save quiet hours queue: " + err.Error())
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// RecapLog holds the changes seen so far today, persisted so the end-of-day
+// recap survives restarts.
+type RecapLog struct {
+	Date      string
+	Changes   []string
+	LastRecap string
+}
+
+func loadRecapLog(filename string) RecapLog {
+	var recap RecapLog
+
+	bytesData, err := os.ReadFile(filename)
+	if err != nil {
+		return recap
+	}
+	if err := json.Unmarshal(bytesData, &recap); err != nil {
+		logWarning("Could not parse recap log, starting a fresh one: " + err.Error())
+		return RecapLog{}
+	}
+	return recap
+}
+
+func saveRecapLog(filename string, recap RecapLog) error {
+	if dryRun {
+		logDebug("Dry run: not saving recap log " + filename)
+		return nil
+	}
+
+	bytesData, err := json.MarshalIndent(recap, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filename, bytesData)
+}
+
+// recordRecapChanges adds changes to today's recap log in filename.
+func recordRecapChanges(filename string, changes []string) {
+	if config.RecapTime == "" || len(changes) == 0 {
+		return
+	}
+
+	recap := loadRecapLog(filename)
+	today := localNow().Format("2006-01-02")
+	if recap.Date != today {
+		recap.Date = today
+		recap.Changes = nil
+	}
+	recap.Changes = append(recap.Changes, changes...)
+
+	if err := saveRecapLog(filename, recap); err != nil {
+		logError("Failed to save recap log: " + err.Error())
+	}
+}
+
+// sendRecapIfDue sends the end-of-day recap once the configured RecapTime has
+// passed, summarizing today's changes from filename and the current class grades.
+func sendRecapIfDue(ctx context.Context, notifier Notifier, filename string, classes []Class) {
+	if config.RecapTime == "" {
+		return
+	}
+
+	at, err := time.Parse("15:04", config.RecapTime)
+	if err != nil {
+		logError(fmt.Sprintf("Invalid recap time %q, expected HH:MM.", config.RecapTime))
+		return
+	}
+	now := localNow()
+	recapAt := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	today := now.Format("2006-01-02")
+
+	recap := loadRecapLog(filename)
+	if now.Before(recapAt) || recap.LastRecap == today {
+		return
+	}
+
+	var changes []string
+	if recap.Date == today {
+		changes = recap.Changes
+	}
+
+	if len(changes) == 0 && config.RecapSkipEmptyDays {
+		logInfo("No changes today, skipping end-of-day recap.")
+	} else {
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "End-of-day recap for %s\n", today)
+		if len(changes) == 0 {
+			sb.WriteString("No changes today.\n")
+		} else {
+			fmt.Fprintf(&sb, "Changes today (%d):\n", len(changes))
+			for _, change := range changes {
+				sb.WriteString(change + "\n")
+			}
+		}
+		sb.WriteString("Current grades:")
+		for _, class := range classes {
+			fmt.Fprintf(&sb, "\n%s: %s", class.Name, classGrade(class))
+		}
+		if err := notifier.Notify(ctx, textMessage(sb.String())); err != nil {
+			logError("Failed to send end-of-day recap: " + err.Error())
+		}
+	}
+
+	recap.LastRecap = today
+	if err := saveRecapLog(filename, recap); err != nil {
+		logError("Failed to save recap log: " + err.Error())
+	}
+}
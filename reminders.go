@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// sendReminders reminds about ungraded assignments due within the next
+// config.ReminderDays days. Assignments already reminded about are kept in
+// store so each one is only reminded once.
+func sendReminders(ctx context.Context, notifier Notifier, store BackupStore, assignments []Assignment) {
+	if config.ReminderDays <= 0 {
+		return
+	}
+
+	reminded := make(map[int64]bool)
+	ids, err := store.LoadReminders()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logWarning("Could not load sent reminders: " + err.Error())
+	}
+	for _, id := range ids {
+		reminded[id] = true
+	}
+
+	today := calendarDate(localNow())
+	horizon := today.AddDate(0, 0, config.ReminderDays+1)
+	changes := &routedChanges{}
+	var stillUpcoming []int64
+	for _, assignment := range assignments {
+		due := calendarDate(assignment.DueDate)
+		if assignment.Grade != "" || due.Before(today) || !due.Before(horizon) {
+			continue
+		}
+		// Only remember assignments still coming up, so the list doesn't grow forever
+		stillUpcoming = append(stillUpcoming, assignment.ID)
+		if reminded[assignment.ID] {
+			continue
+		}
+		changes.add(classRoute(assignment.ClassID, assignment.ClassName), assignment.ClassName, MessageLine{Text: fmt.Sprintf(
+			"Reminder: '%s' in class %s is due %s",
+			assignment.Name, assignment.ClassName, due.Format("Mon Jan 2"))})
+	}
+
+	if len(changes.routes) > 0 {
+		if err := changes.notify(ctx, notifier); err != nil {
+			logError("Failed to send assignment reminders: " + err.Error())
+		}
+	}
+	if dryRun || (len(changes.routes) == 0 && len(stillUpcoming) == len(ids)) {
+		return
+	}
+	if err := store.SaveReminders(stillUpcoming); err != nil {
+		logError("Failed to save sent reminders: " + err.Error())
+	}
+}
+
+// loadReminders reads the IDs of assignments already reminded about.
+func loadReminders(filename string) ([]int64, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func saveReminders(filename string, ids []int64) error {
+	data, err := json.MarshalIndent(ids, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, data)
+}
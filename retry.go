@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// permanentError marks an error that retrying won't fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// stopRetrying wraps err so retry returns it right away instead of trying
+// again.
+func stopRetrying(err error) error {
+	return &permanentError{err: err}
+}
+
+// retryDelayer is implemented by errors that say how long to wait before the
+// next attempt, like a rate limit's Retry-After.
+type retryDelayer interface {
+	retryDelay() time.Duration
+}
+
+// retry calls fn up to attempts times until it succeeds, waiting baseDelay
+// after the first failure and doubling it after each one after that, plus up
+// to 50% jitter. Errors wrapped with stopRetrying end it early and are
+// returned unwrapped. what names the operation in the log.
+func retry(ctx context.Context, what string, attempts int, baseDelay time.Duration, fn func() error) error {
+	delay := baseDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		if attempt >= attempts || ctx.Err() != nil {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		wait := delay
+		if jitter := int64(delay / 2); jitter > 0 {
+			wait += time.Duration(rand.Int64N(jitter))
+		}
+		var delayer retryDelayer
+		if errors.As(err, &delayer) && delayer.retryDelay() > 0 {
+			wait = delayer.retryDelay()
+		} else {
+			delay *= 2
+		}
+		logWarning(fmt.Sprintf("%s attempt %d of %d failed, retrying in %s: %s",
+			what, attempt, attempts, wait.Round(time.Millisecond), err.Error()))
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// archiveTimeFormat is the timestamp in archived state file names, e.g.
+// state_123456-20240115T090000.json.
+const archiveTimeFormat = "20060102T150405"
+
+// rotateStateFile copies filename, if it exists, to a timestamped archive next
+// to it, then prunes archives beyond the newest keep or older than maxAge.
+// Zero disables either limit.
+func rotateStateFile(filename string, keep int, maxAge time.Duration) error {
+	info, err := os.Stat(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Copied rather than moved, so a failed save still leaves the state file
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	archive := fmt.Sprintf("%s-%s%s", base, info.ModTime().Format(archiveTimeFormat), ext)
+	if err := writeFileAtomic(archive, data); err != nil {
+		return err
+	}
+
+	return pruneStateArchives(base, ext, keep, maxAge)
+}
+
+func pruneStateArchives(base, ext string, keep int, maxAge time.Duration) error {
+	matches, err := filepath.Glob(base + "-*" + ext)
+	if err != nil {
+		return err
+	}
+
+	type archive struct {
+		path    string
+		savedAt time.Time
+	}
+	var archives []archive
+	for _, path := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(path, base+"-"), ext)
+		savedAt, err := time.ParseInLocation(archiveTimeFormat, stamp, time.Local)
+		if err != nil {
+			// Not one of ours
+			continue
+		}
+		archives = append(archives, archive{path, savedAt})
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].savedAt.After(archives[j].savedAt) })
+
+	var errs []error
+	for i, a := range archives {
+		if (keep > 0 && i >= keep) || (maxAge > 0 && time.Since(a.savedAt) > maxAge) {
+			if err := os.Remove(a.path); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	// credit to @reteps on github for the powerschool package
+	"ps-diff/powerschool"
+)
+
+// StudentFetcher fetches the data for every student on an account.
+// PowerSchoolSession is the real implementation; tests use a fake.
+type StudentFetcher interface {
+	GetStudents(ctx context.Context, username, password string) ([]*powerschool.StudentDataVO, error)
+}
+
+// PowerSchoolSession keeps the PowerSchool client and login session across
+// polls so we only log in again when the session is rejected.
+type PowerSchoolSession struct {
+	client     *powerschool.PublicPortalServiceJSONPortType
+	session    *powerschool.UserSessionVO
+	studentIDs []int64
+}
+
+func newPowerSchoolSession(cfg Config) *PowerSchoolSession {
+	client := powerschool.Client(cfg.PowerSchoolURL)
+	client.SetHeaders(cfg.PowerSchoolHeaders)
+	client.SetTimeout(time.Duration(cfg.HTTPTimeout))
+	client.SetProxy(cfg.proxyFunc())
+	return &PowerSchoolSession{client: client}
+}
+
+// GetStudents fetches the data for every student on the account, reusing the
+// cached session if there is one and falling back to a fresh login if it's
+// been rejected.
+func (s *PowerSchoolSession) GetStudents(ctx context.Context, username, password string) ([]*powerschool.StudentDataVO, error) {
+	s.client.SetContext(ctx)
+	if s.session != nil {
+		students, err := s.client.GetStudentsWithSession(s.session, s.studentIDs)
+		if err == nil {
+			return students, nil
+		}
+		logWarning("Cached PowerSchool session was rejected, logging in again: " + err.Error())
+		s.session = nil
+	}
+
+	logInfo("Logging in to PowerSchool...")
+	session, studentIDs, err := s.client.CreateUserSession(username, password)
+	if err != nil {
+		return nil, err
+	}
+	s.session = session
+	s.studentIDs = studentIDs
+
+	return s.client.GetStudentsWithSession(session, studentIDs)
+}
+
+// Fetch retries: fetchAttempts tries in total, starting at fetchRetryDelay and
+// doubling after each failure, plus jitter.
+const fetchAttempts = 4
+
+// fetchRetryDelay is a variable so tests can shorten it.
+var fetchRetryDelay = 5 * time.Second
+
+// fetchStudentsWithRetry fetches the students, retrying transient failures
+// with backoff. Rejected credentials aren't retried. It also returns how long
+// the successful attempt took.
+func fetchStudentsWithRetry(ctx context.Context, fetcher StudentFetcher) ([]*powerschool.StudentDataVO, time.Duration, error) {
+	var students []*powerschool.StudentDataVO
+	var latency time.Duration
+	err := retry(ctx, "PowerSchool fetch", fetchAttempts, fetchRetryDelay, func() error {
+		start := time.Now()
+		var err error
+		students, err = fetcher.GetStudents(ctx, config.PowerSchoolUsername, config.PowerSchoolPassword)
+		latency = time.Since(start)
+
+		var loginErr *powerschool.LoginError
+		if errors.As(err, &loginErr) {
+			logError("PowerSchool rejected the login, not retrying. Check powerschool_username and powerschool_password: " + err.Error())
+			return stopRetrying(err)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return students, latency, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// SlackNotifier posts messages to a Slack incoming webhook.
+type SlackNotifier struct {
+	WebhookURL string
+}
+
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+func (s *SlackNotifier) Notify(ctx context.Context, message Message) error {
+	jsonData, err := json.Marshal(slackMessage{Text: message.String()})
+	if err != nil {
+		return fmt.Errorf("marshaling JSON: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.WebhookURL, bytes.NewReader(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Slack answers with a plain-text "ok" on success and an error code
+	// (e.g. "invalid_payload", "no_service") otherwise.
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != "ok" {
+		return fmt.Errorf("slack webhook returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	logSuccess("Slack notification sent!")
+	return nil
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// BackupStore keeps one student's last seen State to diff against, and the
+// assignments already reminded about. Loading before anything was saved
+// returns an error matching fs.ErrNotExist.
+type BackupStore interface {
+	LoadState() (State, error)
+	LoadReminders() ([]int64, error)
+	SaveState(state State) error
+	SaveReminders(assignmentIDs []int64) error
+}
+
+// jsonBackupStore keeps the backups in JSON files. ClassesFile and
+// AssignmentsFile are the separate backups from before StateFile existed,
+// only read until the first save. If KeepArchives or MaxArchiveAge is set,
+// each save first archives the previous StateFile, keeping at most that many
+// archives or archives up to that age.
+type jsonBackupStore struct {
+	StateFile       string
+	ClassesFile     string
+	AssignmentsFile string
+	RemindersFile   string
+	KeepArchives    int
+	MaxArchiveAge   time.Duration
+}
+
+func (s jsonBackupStore) LoadState() (State, error) {
+	state, err := loadState(s.StateFile)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return state, err
+	}
+
+	// Only migrate if both old files are there, so a missing one can't make
+	// everything in it look new
+	classes, err := loadBackupDataClasses(s.ClassesFile)
+	if err != nil {
+		return State{}, err
+	}
+	assignments, err := loadBackupDataAssignments(s.AssignmentsFile)
+	if err != nil {
+		return State{}, err
+	}
+	logInfo(fmt.Sprintf("Migrating %s and %s into %s.", s.ClassesFile, s.AssignmentsFile, s.StateFile))
+	return State{Classes: classes, Assignments: assignments}, nil
+}
+
+func (s jsonBackupStore) LoadReminders() ([]int64, error) {
+	return loadReminders(s.RemindersFile)
+}
+
+// SaveState saves state to StateFile, then removes the old separate backups
+// so they can't be migrated again later.
+func (s jsonBackupStore) SaveState(state State) error {
+	if s.KeepArchives > 0 || s.MaxArchiveAge > 0 {
+		if err := rotateStateFile(s.StateFile, s.KeepArchives, s.MaxArchiveAge); err != nil {
+			logWarning("Could not archive the previous state file: " + err.Error())
+		}
+	}
+	if err := saveState(s.StateFile, state); err != nil {
+		return err
+	}
+	for _, legacy := range []string{s.ClassesFile, s.AssignmentsFile} {
+		if legacy == "" {
+			continue
+		}
+		if err := os.Remove(legacy); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logWarning("Could not remove old backup file: " + err.Error())
+		}
+	}
+	return nil
+}
+
+func (s jsonBackupStore) SaveReminders(assignmentIDs []int64) error {
+	return saveReminders(s.RemindersFile, assignmentIDs)
+}
+
+// backupDB is the SQLite database backups are kept in when storage is
+// "sqlite", opened at startup.
+var backupDB *sql.DB
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS classes (
+	student_id INTEGER NOT NULL,
+	id         INTEGER NOT NULL,
+	data       TEXT NOT NULL,
+	PRIMARY KEY (student_id, id)
+);
+CREATE TABLE IF NOT EXISTS assignments (
+	student_id INTEGER NOT NULL,
+	id         INTEGER NOT NULL,
+	data       TEXT NOT NULL,
+	PRIMARY KEY (student_id, id)
+);
+CREATE TABLE IF NOT EXISTS reminders (
+	student_id INTEGER NOT NULL,
+	id         INTEGER NOT NULL,
+	data       TEXT NOT NULL,
+	PRIMARY KEY (student_id, id)
+);
+CREATE TABLE IF NOT EXISTS terms (
+	student_id INTEGER NOT NULL,
+	id         INTEGER NOT NULL,
+	data       TEXT NOT NULL,
+	PRIMARY KEY (student_id, id)
+);
+CREATE TABLE IF NOT EXISTS attendance (
+	student_id INTEGER NOT NULL,
+	id         INTEGER NOT NULL,
+	data       TEXT NOT NULL,
+	PRIMARY KEY (student_id, id)
+);
+CREATE TABLE IF NOT EXISTS snapshots (
+	student_id INTEGER NOT NULL,
+	kind       TEXT NOT NULL,
+	saved_at   TEXT NOT NULL,
+	PRIMARY KEY (student_id, kind)
+);`
+
+// openBackupDB opens (creating if needed) the SQLite database at path.
+func openBackupDB(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// Students are polled in parallel; one connection keeps their writes from
+	// failing with "database is locked"
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating tables in %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// sqliteBackupStore keeps a student's backups in the shared SQLite database.
+// Each row holds the item as JSON so new fields don't need schema changes.
+type sqliteBackupStore struct {
+	DB        *sql.DB
+	StudentID int64
+}
+
+// LoadState loads the classes and assignments, which SaveState always saves
+// together, and the terms and attendance, which databases from older versions
+// don't have.
+func (s sqliteBackupStore) LoadState() (State, error) {
+	var state State
+	err := s.load("classes", func(data []byte) error {
+		var class Class
+		if err := json.Unmarshal(data, &class); err != nil {
+			return err
+		}
+		state.Classes = append(state.Classes, class)
+		return nil
+	})
+	if err != nil {
+		return State{}, err
+	}
+	err = s.load("assignments", func(data []byte) error {
+		var assignment Assignment
+		if err := json.Unmarshal(data, &assignment); err != nil {
+			return err
+		}
+		state.Assignments = append(state.Assignments, assignment)
+		return nil
+	})
+	if err != nil {
+		return State{}, err
+	}
+	err = s.load("terms", func(data []byte) error {
+		var term Term
+		if err := json.Unmarshal(data, &term); err != nil {
+			return err
+		}
+		state.Terms = append(state.Terms, term)
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return State{}, err
+	}
+	// As in state files, attendance stays nil unless it was tracked
+	attendance := []AttendanceRecord{}
+	err = s.load("attendance", func(data []byte) error {
+		var record AttendanceRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+		attendance = append(attendance, record)
+		return nil
+	})
+	if err == nil {
+		state.Attendance = attendance
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return State{}, err
+	}
+	state.LastRun, err = s.lastSaved()
+	if err != nil {
+		return State{}, err
+	}
+	return state, nil
+}
+
+// lastSaved returns when the student's state was last saved, which is the
+// end of their last successful poll.
+func (s sqliteBackupStore) lastSaved() (time.Time, error) {
+	var savedAt string
+	err := s.DB.QueryRow(`SELECT saved_at FROM snapshots WHERE student_id = ? AND kind = 'classes'`,
+		s.StudentID).Scan(&savedAt)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, savedAt)
+}
+
+func (s sqliteBackupStore) LoadReminders() ([]int64, error) {
+	var ids []int64
+	err := s.load("reminders", func(data []byte) error {
+		var id int64
+		if err := json.Unmarshal(data, &id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+		return nil
+	})
+	return ids, err
+}
+
+// SaveState saves the classes, assignments, terms and, if it's tracked,
+// attendance in one transaction.
+func (s sqliteBackupStore) SaveState(state State) error {
+	classes := make(map[int64]any, len(state.Classes))
+	for _, class := range state.Classes {
+		classes[class.ID] = class
+	}
+	assignments := make(map[int64]any, len(state.Assignments))
+	for _, assignment := range state.Assignments {
+		assignments[assignment.ID] = assignment
+	}
+	terms := make(map[int64]any, len(state.Terms))
+	for _, term := range state.Terms {
+		terms[term.ID] = term
+	}
+	tables := map[string]map[int64]any{"classes": classes, "assignments": assignments, "terms": terms}
+	if state.Attendance != nil {
+		attendance := make(map[int64]any, len(state.Attendance))
+		for _, record := range state.Attendance {
+			attendance[record.ID] = record
+		}
+		tables["attendance"] = attendance
+	}
+	return s.save(tables)
+}
+
+func (s sqliteBackupStore) SaveReminders(assignmentIDs []int64) error {
+	rows := make(map[int64]any, len(assignmentIDs))
+	for _, id := range assignmentIDs {
+		rows[id] = id
+	}
+	return s.save(map[string]map[int64]any{"reminders": rows})
+}
+
+// load calls decode with each row's data from table, in ID order.
+func (s sqliteBackupStore) load(table string, decode func(data []byte) error) error {
+	var savedAt string
+	err := s.DB.QueryRow(`SELECT saved_at FROM snapshots WHERE student_id = ? AND kind = ?`,
+		s.StudentID, table).Scan(&savedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no %s saved for student %d: %w", table, s.StudentID, fs.ErrNotExist)
+	}
+	if err != nil {
+		return err
+	}
+
+	// table is one of our own constants, never user input
+	rows, err := s.DB.Query(`SELECT data FROM `+table+` WHERE student_id = ? ORDER BY id`, s.StudentID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		if err := decode(data); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// save replaces the student's rows in each table with the given rows, keyed
+// by table name, in one transaction.
+func (s sqliteBackupStore) save(tables map[string]map[int64]any) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for table, rows := range tables {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE student_id = ?`, s.StudentID); err != nil {
+			return err
+		}
+		for id, row := range rows {
+			data, err := json.Marshal(row)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`INSERT INTO `+table+` (student_id, id, data) VALUES (?, ?, ?)`,
+				s.StudentID, id, data); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO snapshots (student_id, kind, saved_at) VALUES (?, ?, ?)`,
+			s.StudentID, table, time.Now().Format(time.RFC3339)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
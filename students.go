@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"ps-diff/powerschool"
+)
+
+// studentName returns the name used to label a student's notifications.
+func studentName(student *powerschool.StudentDataVO) string {
+	if student.Student != nil && student.Student.FirstName != "" {
+		return student.Student.FirstName
+	}
+	return strconv.FormatInt(student.StudentId, 10)
+}
+
+// studentMonitored reports whether the Students setting includes student.
+func studentMonitored(student *powerschool.StudentDataVO) bool {
+	if len(config.Students) == 0 {
+		return true
+	}
+
+	for _, wanted := range config.Students {
+		if studentMatches(student, wanted) {
+			return true
+		}
+	}
+	return false
+}
+
+// studentMatches reports whether a Students or student_settings entry names
+// student, by student ID, first name or full name, ignoring case.
+func studentMatches(student *powerschool.StudentDataVO, wanted string) bool {
+	candidates := []string{strconv.FormatInt(student.StudentId, 10)}
+	if student.Student != nil {
+		candidates = append(candidates,
+			student.Student.FirstName,
+			student.Student.FirstName+" "+student.Student.LastName)
+	}
+	for _, candidate := range candidates {
+		if strings.EqualFold(strings.TrimSpace(wanted), candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// StudentSettings overrides the global quiet_hours, quiet_weekends and
+// holidays for one student. Settings left out fall back to the global ones.
+type StudentSettings struct {
+	QuietHours    *string   `json:"quiet_hours" yaml:"quiet_hours"`
+	QuietWeekends *bool     `json:"quiet_weekends" yaml:"quiet_weekends"`
+	Holidays      *[]string `json:"holidays" yaml:"holidays"`
+}
+
+// studentSettingsKey returns the student_settings entry for student, if any.
+func studentSettingsKey(student *powerschool.StudentDataVO) (string, bool) {
+	keys := make([]string, 0, len(config.StudentSettings))
+	for key := range config.StudentSettings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if studentMatches(student, key) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// studentFilePath inserts the student ID before the file extension, so each
+// student gets their own state and backup files, e.g. state_1234.json.
+func studentFilePath(base string, studentID int64) string {
+	return suffixFilePath(base, strconv.FormatInt(studentID, 10))
+}
+
+// suffixFilePath inserts "_suffix" before the file extension.
+func suffixFilePath(base, suffix string) string {
+	ext := filepath.Ext(base)
+	return fmt.Sprintf("%s_%s%s", strings.TrimSuffix(base, ext), suffix, ext)
+}
+
+// migrateLegacyFile renames a file written before per-student files existed to
+// its per-student path, so single-student setups keep their history. It
+// returns the path to read the data from, which stays the legacy file during
+// a dry run.
+func migrateLegacyFile(legacy, path string) string {
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return path
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		return path
+	}
+	if dryRun {
+		logInfo(fmt.Sprintf("Dry run: would move %s to %s.", legacy, path))
+		return legacy
+	}
+	if err := os.Rename(legacy, path); err != nil {
+		logWarning(fmt.Sprintf("Could not move %s to %s: %s", legacy, path, err))
+		return legacy
+	}
+	logInfo(fmt.Sprintf("Moved %s to %s.", legacy, path))
+	return path
+}
+
+// prefixNotifier labels every message with the student it's about.
+type prefixNotifier struct {
+	Notifier Notifier
+	Prefix   string
+}
+
+func (p prefixNotifier) Notify(ctx context.Context, message Message) error {
+	return p.Notifier.Notify(ctx, message.prefixed(p.Prefix))
+}
+
+func (p prefixNotifier) NotifyRoute(ctx context.Context, route string, message Message) error {
+	return notifyRoute(ctx, p.Notifier, route, message.prefixed(p.Prefix))
+}
+
+// dryRunNotifier logs the notifications that would have been sent.
+type dryRunNotifier struct{}
+
+func (dryRunNotifier) Notify(ctx context.Context, message Message) error {
+	logInfo("Dry run: would notify:\n" + message.String())
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Telegram rejects messages longer than this many characters.
+const telegramMessageLimit = 4096
+
+// TelegramNotifier sends messages to a chat through the Telegram Bot API.
+type TelegramNotifier struct {
+	BotToken string
+	ChatID   string
+}
+
+type telegramMessage struct {
+	ChatID string `json:"chat_id"`
+	Text   string `json:"text"`
+}
+
+type telegramResponse struct {
+	OK          bool   `json:"ok"`
+	Description string `json:"description"`
+}
+
+func (t *TelegramNotifier) Notify(ctx context.Context, message Message) error {
+	chunks := splitMessage(message.String(), telegramMessageLimit)
+	for i, chunk := range chunks {
+		if err := t.send(ctx, chunk); err != nil {
+			return fmt.Errorf("sending Telegram message %d of %d: %w", i+1, len(chunks), err)
+		}
+	}
+	logSuccess(fmt.Sprintf("Telegram notification sent (%d message(s))!", len(chunks)))
+	return nil
+}
+
+func (t *TelegramNotifier) send(ctx context.Context, text string) error {
+	jsonData, err := json.Marshal(telegramMessage{ChatID: t.ChatID, Text: text})
+	if err != nil {
+		return fmt.Errorf("marshaling JSON: %w", err)
+	}
+
+	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", t.BotToken)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result telegramResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("telegram returned %s", resp.Status)
+	}
+	if !result.OK {
+		return fmt.Errorf("telegram returned %s: %s", resp.Status, result.Description)
+	}
+	return nil
+}
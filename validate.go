@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// writablePaths returns the files the poller will write with this config,
+// keyed by the setting that names them.
+func (cfg Config) writablePaths() map[string]string {
+	paths := make(map[string]string)
+	if cfg.Storage == "sqlite" {
+		paths["database_file"] = cfg.DatabaseFile
+	} else {
+		paths["state_file"] = cfg.StateFile
+	}
+	for _, name := range cfg.enabledNotifiers() {
+		paths["notification_queue_file ("+name+")"] = suffixFilePath(cfg.NotificationQueueFile, name)
+	}
+	if cfg.NotifyMode == "digest" {
+		paths["digest_file"] = cfg.DigestFile
+	}
+	if cfg.quietHoursEnabled() {
+		paths["quiet_hours_file"] = cfg.QuietHoursFile
+	}
+	if cfg.RecapTime != "" {
+		paths["recap_changes_file"] = cfg.RecapChangesFile
+	}
+	if cfg.ReminderDays > 0 {
+		paths["reminders_file"] = cfg.RemindersFile
+	}
+	if cfg.DedupeWindow > 0 {
+		paths["dedupe_file"] = cfg.DedupeFile
+	}
+	if cfg.HistoryFile != "" {
+		paths["history_file"] = cfg.HistoryFile
+	}
+	return paths
+}
+
+// checkWritable checks that a file can be created next to path, without
+// touching path itself.
+func checkWritable(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".ps-diff-validate-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// validateSetup loads the config the way a normal run would and writes an
+// OK/FAIL line for each check to w, without contacting PowerSchool or any
+// notifier. It reports whether every check passed.
+func validateSetup(w io.Writer, configPath, intervalFlag string) bool {
+	ok := true
+	report := func(err error, format string, args ...any) {
+		line := fmt.Sprintf(format, args...)
+		if err != nil {
+			ok = false
+			fmt.Fprintf(w, "FAIL  %s: %s\n", line, strings.ReplaceAll(err.Error(), "\n", "\n      "))
+			return
+		}
+		fmt.Fprintf(w, "OK    %s\n", line)
+	}
+
+	source := "defaults and environment"
+	if configPath != "" {
+		source = configPath
+	}
+	cfg, err := loadConfig(configPath)
+	report(err, "configuration from %s", source)
+
+	if intervalFlag != "" {
+		cfg.PollInterval = intervalFlag
+	}
+	_, err = parsePollInterval(cfg.PollInterval)
+	report(err, "poll interval %s", cfg.PollInterval)
+
+	paths := cfg.writablePaths()
+	for _, key := range slices.Sorted(maps.Keys(paths)) {
+		report(checkWritable(paths[key]), "%s %s is writable", key, paths[key])
+	}
+
+	if ok {
+		fmt.Fprintf(w, "\nConfiguration is valid, notifying through %s.\n", strings.Join(cfg.enabledNotifiers(), ", "))
+	} else {
+		fmt.Fprintln(w, "\nConfiguration has problems, see above.")
+	}
+	return ok
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// defaultWebhookBody is the generic webhook's body unless webhook_body is set.
+const defaultWebhookBody = `{"message": {{json .Message}}}`
+
+// webhookTemplateFuncs are available in webhook_body. json quotes a value as
+// JSON, so messages with quotes or newlines still make a valid body.
+var webhookTemplateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// GenericWebhookNotifier sends messages to any HTTP endpoint (Home Assistant,
+// IFTTT, n8n, ...) as a JSON body rendered from Body, a text/template given a
+// webhookPayload.
+type GenericWebhookNotifier struct {
+	URL     string
+	Method  string
+	Headers map[string]string
+	Body    string
+}
+
+type webhookPayload struct {
+	Message string
+}
+
+func parseWebhookBody(body string) (*template.Template, error) {
+	return template.New("webhook_body").Funcs(webhookTemplateFuncs).Parse(body)
+}
+
+func (w *GenericWebhookNotifier) Notify(ctx context.Context, message Message) error {
+	tmpl, err := parseWebhookBody(w.Body)
+	if err != nil {
+		return fmt.Errorf("parsing webhook_body: %w", err)
+	}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, webhookPayload{Message: message.String()}); err != nil {
+		return fmt.Errorf("rendering webhook_body: %w", err)
+	}
+	if !json.Valid(body.Bytes()) {
+		return errors.New("webhook_body didn't render to valid JSON: " + body.String())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, w.Method, w.URL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range w.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &webhookStatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(respBody))}
+	}
+	logSuccess("Webhook notification sent (" + resp.Status + ")!")
+	return nil
+}